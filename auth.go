@@ -2,44 +2,248 @@ package gitauto
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
 
+	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/errors"
 )
 
-type authContainer struct {
-	authMap sync.Map
+// authPattern 验证配置匹配规则,格式为 [user@]host[:port][/path/prefix],host 可为 *.example.com 匹配所有子域名;
+// 省略的部分匹配任意值
+type authPattern struct {
+	user       string
+	host       string // 小写,通配时为去掉 "*." 的域名
+	wildcard   bool
+	port       string
+	pathPrefix string // 不以"/"开头结尾,不含 .git 后缀
+}
+
+// parseAuthPattern 解析匹配规则,如 gitea.example.com、git@gitea.example.com:2221、github.com/org、*.example.com、[::1]:2222
+func parseAuthPattern(pattern string) (p authPattern, err error) {
+	u, err := url.Parse("//" + strings.TrimSpace(pattern))
+	if err != nil {
+		return p, errors.WithMessagef(err, "invalid auth pattern %q", pattern)
+	}
+	if u.User != nil {
+		p.user = u.User.Username()
+	}
+	p.pathPrefix = strings.TrimSuffix(strings.Trim(u.Path, "/"), git.GitDirName)
+	host := u.Host
+	if strings.LastIndex(host, ":") > strings.LastIndex(host, "]") {
+		host, p.port, err = net.SplitHostPort(host)
+		if err != nil {
+			return p, errors.WithMessagef(err, "invalid auth pattern %q", pattern)
+		}
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	if strings.HasPrefix(host, "*.") {
+		p.wildcard = true
+		host = strings.TrimPrefix(host, "*.")
+	}
+	if host == "" || strings.Contains(host, "*") {
+		err = errors.Errorf("invalid auth pattern %q", pattern)
+		return p, err
+	}
+	p.host = host
+	return p, nil
+}
+
+func (p authPattern) String() string {
+	s := p.host
+	if p.wildcard {
+		s = "*." + s
+	}
+	if p.port != "" {
+		s = net.JoinHostPort(s, p.port)
+	} else if strings.Contains(s, ":") { // IPv6
+		s = "[" + s + "]"
+	}
+	if p.user != "" {
+		s = p.user + "@" + s
+	}
+	if p.pathPrefix != "" {
+		s = s + "/" + p.pathPrefix
+	}
+	return s
+}
+
+// defaultPorts 地址未指定端口时按协议使用的端口,规则中的端口与之比较
+var defaultPorts = map[string]string{
+	"https": "443",
+	"http":  "80",
+	"ssh":   "22",
+}
+
+// urlPort 地址的端口,未指定时为协议的默认端口
+func urlPort(u *url.URL) (port string) {
+	port = u.Port()
+	if port == "" {
+		port = defaultPorts[strings.ToLower(u.Scheme)]
+	}
+	return port
+}
+
+// match 仓库地址是否匹配规则
+func (p authPattern) match(u *url.URL) bool {
+	hostname := strings.ToLower(u.Hostname())
+	if p.wildcard {
+		if !strings.HasSuffix(hostname, "."+p.host) {
+			return false
+		}
+	} else if hostname != p.host {
+		return false
+	}
+	if p.port != "" && urlPort(u) != p.port {
+		return false
+	}
+	if p.user != "" && u.User.Username() != p.user {
+		return false
+	}
+	if p.pathPrefix != "" {
+		repoPath := strings.TrimSuffix(strings.Trim(u.Path, "/"), git.GitDirName)
+		if !strings.HasPrefix(repoPath+"/", p.pathPrefix+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// moreSpecific 多条规则匹配同一地址时的优先级,依次比较:
+// 确定主机名优先于通配(通配时域名越长越优先)、指定端口优先、路径前缀越长越优先、指定用户名优先;都相同时 Get 按规则字符串排序
+func (p authPattern) moreSpecific(other authPattern) bool {
+	if p.wildcard != other.wildcard {
+		return !p.wildcard
+	}
+	if p.wildcard && len(p.host) != len(other.host) {
+		return len(p.host) > len(other.host)
+	}
+	if (p.port != "") != (other.port != "") {
+		return p.port != ""
+	}
+	pSegments, otherSegments := pathSegments(p.pathPrefix), pathSegments(other.pathPrefix)
+	if pSegments != otherSegments {
+		return pSegments > otherSegments
+	}
+	return p.user != "" && other.user == ""
 }
 
-func (a *authContainer) Register(hostname string, auth transport.AuthMethod) {
-	a.authMap.Store(hostname, auth)
+func pathSegments(path string) int {
+	if path == "" {
+		return 0
+	}
+	return strings.Count(path, "/") + 1
 }
-func (a *authContainer) Get(hostname string) (auth transport.AuthMethod, ok bool) {
-	value, ok := a.authMap.Load(hostname)
-	if !ok {
-		return nil, false
+
+type authEntry struct {
+	pattern authPattern
+	auth    transport.AuthMethod
+}
+
+// authContainer 按规则注册的验证配置,同一规则重复注册时覆盖
+type authContainer struct {
+	authMap sync.Map // 规则 => authEntry
+}
+
+func (a *authContainer) Register(pattern string, auth transport.AuthMethod) (err error) {
+	p, err := parseAuthPattern(pattern)
+	if err != nil {
+		return err
 	}
-	auth, ok = value.(transport.AuthMethod)
-	if !ok {
-		return nil, false
+	a.authMap.Store(p.String(), authEntry{pattern: p, auth: auth})
+	return nil
+}
+
+// Get 获取与地址匹配的最具体规则的验证配置
+func (a *authContainer) Get(u *url.URL) (auth transport.AuthMethod, pattern string, ok bool) {
+	var best *authEntry
+	a.authMap.Range(func(key, value interface{}) bool {
+		entry := value.(authEntry)
+		if !entry.pattern.match(u) {
+			return true
+		}
+		if best == nil || entry.pattern.moreSpecific(best.pattern) {
+			best = &entry
+			return true
+		}
+		if !best.pattern.moreSpecific(entry.pattern) && entry.pattern.String() < best.pattern.String() { // 同样具体时按规则排序,结果与遍历顺序无关
+			best = &entry
+		}
+		return true
+	})
+	if best == nil {
+		return nil, "", false
 	}
+	return best.auth, best.pattern.String(), true
+}
 
-	return auth, true
+// Patterns 已注册的规则
+func (a *authContainer) Patterns() (patterns []string) {
+	patterns = make([]string, 0)
+	a.authMap.Range(func(key, value interface{}) bool {
+		patterns = append(patterns, key.(string))
+		return true
+	})
+	sort.Strings(patterns)
+	return patterns
 }
 
 var _authContainer = authContainer{}
 
+// RegisterAuth 为 用户名@主机名 注册验证配置,username 为空时匹配该主机的任意用户,hostname 可带端口;更多匹配方式见 RegisterAuthPattern
 func RegisterAuth(username string, hostname string, auth transport.AuthMethod) {
-	key := getAuthMapKey(username, hostname)
-	_authContainer.Register(key, auth)
+	DefaultClient.RegisterAuth(username, hostname, auth)
+}
+
+// RegisterAuthPattern 按规则注册验证配置,使用 DefaultClient
+func RegisterAuthPattern(pattern string, auth transport.AuthMethod) (err error) {
+	return DefaultClient.RegisterAuthPattern(pattern, auth)
 }
 
 func GetAuth(username string, hostname string) (auth transport.AuthMethod, ok bool) {
-	key := getAuthMapKey(username, hostname)
-	auth, ok = _authContainer.Get(key)
+	return DefaultClient.GetAuth(username, hostname)
+}
+
+// RegisterAuth 注册验证配置,仅对当前 Client 生效
+func (c *Client) RegisterAuth(username string, hostname string, auth transport.AuthMethod) {
+	err := c.RegisterAuthPattern(getAuthMapKey(username, hostname), auth)
+	if err != nil {
+		c.logf("gitauto: register auth: %v", err)
+	}
+}
+
+// RegisterAuthPattern 按规则注册验证配置,规则格式为 [user@]host[:port][/path/prefix],如:
+//
+//	gitea.example.com            该主机任意端口、任意用户、任意仓库
+//	gitea.example.com:2221       只匹配该端口
+//	robot@gitea.example.com      只匹配地址用户名为 robot
+//	gitea.example.com/org/group  只匹配 org/group 下的仓库
+//	*.example.com                example.com 的所有子域名
+//	[::1]:2222                   IPv6 地址需用方括号
+//
+// 地址未指定端口时按协议的默认端口(https 443、http 80、ssh 22)匹配规则中的端口
+// 多条规则匹配同一地址时使用最具体的规则,优先级见 authPattern.moreSpecific
+func (c *Client) RegisterAuthPattern(pattern string, auth transport.AuthMethod) (err error) {
+	return c.auth.Register(pattern, auth)
+}
+
+// GetAuth 获取当前 Client 注册的验证配置
+func (c *Client) GetAuth(username string, hostname string) (auth transport.AuthMethod, ok bool) {
+	u := &url.URL{Host: hostname}
+	if username != "" {
+		u.User = url.User(username)
+	}
+	auth, _, ok = c.auth.Get(u)
 	return auth, ok
 }
 
 func getAuthMapKey(username string, hostname string) (key string) {
+	if username == "" {
+		return hostname
+	}
 	return fmt.Sprintf("%s@%s", username, hostname)
 }
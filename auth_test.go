@@ -0,0 +1,108 @@
+package gitauto
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthPattern(t *testing.T) {
+	patterns := []string{
+		"gitea.example.com",
+		"gitea.example.com:2221",
+		"robot@gitea.example.com",
+		"gitea.example.com/org",
+		"gitea.example.com/org/group",
+		"gitea.example.com:2221/org",
+		"*.example.com",
+		"*.ci.example.com",
+		"robot@*.example.com",
+	}
+	container := &authContainer{}
+	for _, pattern := range patterns {
+		err := container.Register(pattern, &http.BasicAuth{Username: pattern})
+		require.NoError(t, err)
+	}
+	cases := []struct {
+		address  string
+		expected string
+	}{
+		{"https://gitea.example.com/other/repo.git", "gitea.example.com"},
+		{"ssh://git@gitea.example.com:2221/other/repo.git", "gitea.example.com:2221"},
+		{"https://robot@gitea.example.com/other/repo.git", "robot@gitea.example.com"},
+		{"https://gitea.example.com/org/repo.git", "gitea.example.com/org"},
+		{"https://gitea.example.com/organization/repo.git", "gitea.example.com"},
+		{"https://gitea.example.com/org/group/repo.git", "gitea.example.com/org/group"},
+		{"https://robot@gitea.example.com/org/repo.git", "gitea.example.com/org"},                 // 路径前缀优先于用户名
+		{"ssh://git@gitea.example.com:2221/org/group/repo.git", "gitea.example.com:2221/org"},     // 端口优先于路径前缀
+		{"https://git.example.com/org/repo.git", "*.example.com"},                                 // 子域名
+		{"https://robot@git.example.com/org/repo.git", "robot@*.example.com"},                     // 通配时同样用户名优先
+		{"https://build.ci.example.com/org/repo.git", "*.ci.example.com"},                         // 通配域名越长越优先
+		{"https://robot@gitea.example.com:2221/org/group/repo.git", "gitea.example.com:2221/org"}, // 确定主机名优先于通配
+	}
+	for _, c := range cases {
+		rf, err := parseRepositoryURL(c.address)
+		require.NoError(t, err)
+		_, pattern, ok := container.Get(rf.URL())
+		require.True(t, ok, c.address)
+		assert.Equal(t, c.expected, pattern, c.address)
+	}
+	for _, address := range []string{"https://example.com/org/repo.git", "https://github.com/org/repo.git"} {
+		rf, err := parseRepositoryURL(address)
+		require.NoError(t, err)
+		_, _, ok := container.Get(rf.URL())
+		assert.False(t, ok, address)
+	}
+
+	t.Run("default port and IPv6", func(t *testing.T) {
+		container := &authContainer{}
+		for _, pattern := range []string{"gitea.example.com:443", "git@gitea.example.com:22", "[::1]:2222", "[fd00::1]"} {
+			err := container.Register(pattern, &http.BasicAuth{Username: pattern})
+			require.NoError(t, err)
+		}
+		cases := []struct {
+			address  string
+			expected string
+		}{
+			{"https://gitea.example.com/org/repo.git", "gitea.example.com:443"},
+			{"https://gitea.example.com:443/org/repo.git", "gitea.example.com:443"},
+			{"git@gitea.example.com:org/repo.git", "git@gitea.example.com:22"},
+			{"ssh://git@[::1]:2222/org/repo.git", "[::1]:2222"},
+			{"ssh://git@[fd00::1]/org/repo.git", "[fd00::1]"},
+		}
+		for _, c := range cases {
+			rf, err := parseRepositoryURL(c.address)
+			require.NoError(t, err)
+			_, pattern, ok := container.Get(rf.URL())
+			require.True(t, ok, c.address)
+			assert.Equal(t, c.expected, pattern, c.address)
+		}
+		for _, address := range []string{"http://gitea.example.com/org/repo.git", "ssh://git@[::1]/org/repo.git"} {
+			rf, err := parseRepositoryURL(address)
+			require.NoError(t, err)
+			_, _, ok := container.Get(rf.URL())
+			assert.False(t, ok, address)
+		}
+	})
+
+	_, err := parseAuthPattern("gitea.*.com")
+	require.Error(t, err)
+	_, err = parseAuthPattern("robot@")
+	require.Error(t, err)
+	_, err = parseAuthPattern("gitea.example.com:ssh")
+	require.Error(t, err)
+
+	t.Run("RegisterAuth", func(t *testing.T) {
+		c := NewClient()
+		auth := &http.BasicAuth{Username: "robot"}
+		c.RegisterAuth("", "gitea.example.com", auth)
+		got, ok := c.GetAuth("git", "gitea.example.com:2221")
+		require.True(t, ok, "host without port applies to any port and user")
+		assert.Equal(t, auth, got)
+		c.RegisterAuth("git", "gitea.example.com:2221", &http.BasicAuth{Username: "git"})
+		got, _ = c.GetAuth("git", "gitea.example.com:2221")
+		assert.Equal(t, "git", got.(*http.BasicAuth).Username)
+	})
+}
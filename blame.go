@@ -0,0 +1,344 @@
+package gitauto
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/pkg/errors"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// blameCacheDirName blame 缓存目录,位于 .git/gitauto 下,按文件路径分目录,每个提交一个文件
+const blameCacheDirName = "blame"
+
+// BlameCacheSize 每个文件保留的 blame 缓存数量,超过时删除最早写入的
+var BlameCacheSize = 16
+
+// BlameCacheDistance 增量更新时沿第一父提交向前查找缓存的最大提交数,超过时完整 blame
+var BlameCacheDistance = 200
+
+// errBlameLengthMismatch git.Blame 无法处理某些历史时返回的错误,go-git 未导出该错误,由 gitBlame 转换
+var errBlameLengthMismatch = errors.New("contents and commits have different length")
+
+// blameCache 某提交下文件的 blame 结果
+type blameCache struct {
+	Commit string
+	Path   string
+	Lines  LineCodeAuthors
+}
+
+// BlameAt 获取文件在某版本(分支、标签、提交)每行作者,结果缓存在 .git/gitauto/blame;
+// 该版本没有缓存时,沿第一父提交找到最近的缓存,只重新归属之后修改过的行。作者按该版本中的 .mailmap 解析,见 Repository.Identity
+func (rc *Repository) BlameAt(remoteOrLocalFilename string, rev string) (lineCodeAuthors LineCodeAuthors, err error) {
+	commit, err := rc.resolveCommit(rev)
+	if err != nil {
+		return nil, err
+	}
+	filename := rc.repositoryFilename(remoteOrLocalFilename)
+	lineCodeAuthors, err = rc.blame(commit, filename)
+	if err != nil {
+		return nil, err
+	}
+	return rc.resolveAuthors(commit, lineCodeAuthors)
+}
+
+// blame 每行作者为提交中的原始名称、邮箱(未经 .mailmap 解析),缓存的也是原始值,.mailmap 修改后无需重新计算
+func (rc *Repository) blame(commit *object.Commit, filename string) (lineCodeAuthors LineCodeAuthors, err error) {
+	cacheDir := rc.blameCacheDir(filename)
+	if cacheDir == "" { // 内存仓库不缓存
+		return rc.fullBlame(commit, filename)
+	}
+	cached, ok := readBlameCache(cacheDir, commit.Hash)
+	if ok {
+		return cached.Lines, nil
+	}
+	lineCodeAuthors, err = rc.incrementalBlame(commit, filename, cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	err = writeBlameCache(cacheDir, blameCache{Commit: commit.Hash.String(), Path: filename, Lines: lineCodeAuthors})
+	if err != nil {
+		rc._client.logf("gitauto: write blame cache %s: %v", filename, err)
+	}
+	return lineCodeAuthors, nil
+}
+
+// blameCacheDir 文件的缓存目录,内存仓库返回空
+func (rc *Repository) blameCacheDir(filename string) (dir string) {
+	storage, ok := rc._r.Storer.(*filesystem.Storage)
+	if !ok {
+		return ""
+	}
+	sum := sha1.Sum([]byte(filename))
+	return filepath.Join(storage.Filesystem().Root(), MetaDirName, blameCacheDirName, hex.EncodeToString(sum[:]))
+}
+
+// fullBlame 使用 git.Blame 完整计算,git.Blame 无法处理的历史("contents and commits have different length")改用 linearBlame,其它错误直接返回
+func (rc *Repository) fullBlame(commit *object.Commit, filename string) (lineCodeAuthors LineCodeAuthors, err error) {
+	r := rc._r
+	blameResult, err := gitBlame(commit, filename)
+	if errors.Is(err, errBlameLengthMismatch) {
+		rc._client.logf("gitauto: blame %s at %s: %v, fall back to linear blame", filename, commit.Hash, err)
+		return linearBlame(commit, filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lineCodeAuthors = make(LineCodeAuthors, 0, len(blameResult.Lines))
+	commits := make(map[plumbing.Hash]*object.Commit) // git.Blame 只返回邮箱,名称从提交中读取
+	for i, line := range blameResult.Lines {
+		c, ok := commits[line.Hash]
+		if !ok {
+			c, err = r.CommitObject(line.Hash)
+			if err != nil {
+				return nil, err
+			}
+			commits[line.Hash] = c
+		}
+		lineAuthor := LineWithAuthor{
+			LinNo:  i,
+			Text:   line.Text,
+			Author: Author{Name: c.Author.Name, Email: line.Author},
+			Time:   line.Date,
+			Commit: line.Hash.String(),
+		}
+		lineCodeAuthors = append(lineCodeAuthors, lineAuthor)
+	}
+	return lineCodeAuthors, nil
+}
+
+// gitBlame 同 git.Blame,go-git 以未导出的错误报告内容与提交行数不一致,错误信息完全相同时转换为 errBlameLengthMismatch
+func gitBlame(commit *object.Commit, filename string) (result *git.BlameResult, err error) {
+	result, err = git.Blame(commit, filename)
+	if err != nil && err.Error() == errBlameLengthMismatch.Error() {
+		return nil, errBlameLengthMismatch
+	}
+	return result, err
+}
+
+// linearBlame 沿第一父提交找到文件出现的提交,从该提交起按每个提交的行差异重新归属修改过的行
+func linearBlame(commit *object.Commit, filename string) (lineCodeAuthors LineCodeAuthors, err error) {
+	chain := []*object.Commit{commit}
+	for current := commit; current.NumParents() > 0; {
+		current, err = current.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		_, err = current.File(filename)
+		if errors.Is(err, object.ErrFileNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, current)
+	}
+	lineCodeAuthors = make(LineCodeAuthors, 0)
+	oldContent := ""
+	for i := len(chain) - 1; i >= 0; i-- {
+		f, err := chain[i].File(filename)
+		if err != nil {
+			return nil, err
+		}
+		newContent, err := f.Contents()
+		if err != nil {
+			return nil, err
+		}
+		if newContent != oldContent {
+			lineCodeAuthors = reattribute(lineCodeAuthors, oldContent, newContent, chain[i])
+		}
+		oldContent = newContent
+	}
+	return lineCodeAuthors, nil
+}
+
+// incrementalBlame 沿第一父提交找到最近的缓存,按每个提交的行差异重新归属修改过的行;
+// 找不到缓存、途中文件被删除或由合并提交修改时完整 blame
+func (rc *Repository) incrementalBlame(commit *object.Commit, filename string, cacheDir string) (lineCodeAuthors LineCodeAuthors, err error) {
+	chain := []*object.Commit{commit}
+	parent := commit
+	for {
+		if len(chain) > BlameCacheDistance || parent.NumParents() == 0 {
+			return rc.fullBlame(commit, filename)
+		}
+		parent, err = parent.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		if cached, ok := readBlameCache(cacheDir, parent.Hash); ok {
+			lineCodeAuthors = cached.Lines
+			break
+		}
+		chain = append(chain, parent)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		child := chain[i]
+		changed, oldContent, newContent, err := fileChange(parent, child, filename)
+		if err != nil {
+			if errors.Is(err, object.ErrFileNotFound) {
+				return rc.fullBlame(commit, filename)
+			}
+			return nil, err
+		}
+		if changed {
+			if child.NumParents() > 1 {
+				return rc.fullBlame(commit, filename)
+			}
+			lineCodeAuthors = reattribute(lineCodeAuthors, oldContent, newContent, child)
+		}
+		parent = child
+	}
+	return lineCodeAuthors, nil
+}
+
+// fileChange 文件在两个提交间是否变化,变化时返回前后内容
+func fileChange(parent *object.Commit, child *object.Commit, filename string) (changed bool, oldContent string, newContent string, err error) {
+	oldFile, err := parent.File(filename)
+	if err != nil {
+		return false, "", "", err
+	}
+	newFile, err := child.File(filename)
+	if err != nil {
+		return false, "", "", err
+	}
+	if oldFile.Hash == newFile.Hash {
+		return false, "", "", nil
+	}
+	oldContent, err = oldFile.Contents()
+	if err != nil {
+		return false, "", "", err
+	}
+	newContent, err = newFile.Contents()
+	if err != nil {
+		return false, "", "", err
+	}
+	return true, oldContent, newContent, nil
+}
+
+// reattribute 未修改的行保留原归属,新增、修改的行归属于 commit
+func reattribute(old LineCodeAuthors, oldContent string, newContent string, commit *object.Commit) (lineCodeAuthors LineCodeAuthors) {
+	lineCodeAuthors = make(LineCodeAuthors, 0, len(old))
+	oldIndex := 0
+	for _, d := range diff.Do(oldContent, newContent) {
+		lines := splitLines(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			for range lines {
+				if oldIndex < len(old) {
+					lineCodeAuthors = append(lineCodeAuthors, old[oldIndex])
+				}
+				oldIndex++
+			}
+		case diffmatchpatch.DiffDelete:
+			oldIndex += len(lines)
+		case diffmatchpatch.DiffInsert:
+			for _, line := range lines {
+				lineCodeAuthors = append(lineCodeAuthors, LineWithAuthor{
+					Text:   line,
+					Author: Author{Name: commit.Author.Name, Email: commit.Author.Email},
+					Time:   commit.Author.When,
+					Commit: commit.Hash.String(),
+				})
+			}
+		}
+	}
+	for i := range lineCodeAuthors {
+		lineCodeAuthors[i].LinNo = i
+	}
+	return lineCodeAuthors
+}
+
+// splitLines 同 object.File.Lines,去掉末尾换行后的空行
+func splitLines(text string) (lines []string) {
+	if text == "" {
+		return nil
+	}
+	lines = strings.Split(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func readBlameCache(cacheDir string, hash plumbing.Hash) (cache blameCache, ok bool) {
+	b, err := os.ReadFile(filepath.Join(cacheDir, hash.String()+".json"))
+	if err != nil {
+		return cache, false
+	}
+	err = json.Unmarshal(b, &cache)
+	if err != nil || cache.Commit != hash.String() {
+		return cache, false
+	}
+	return cache, true
+}
+
+// writeBlameCache 先写临时文件再改名,并发读取不会读到写了一半的缓存;超过 BlameCacheSize 时删除最早写入的
+func writeBlameCache(cacheDir string, cache blameCache) (err error) {
+	err = os.MkdirAll(cacheDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(cacheDir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	err = os.Rename(f.Name(), filepath.Join(cacheDir, cache.Commit+".json"))
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return pruneBlameCache(cacheDir)
+}
+
+func pruneBlameCache(cacheDir string) (err error) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return err
+	}
+	caches := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		caches = append(caches, info)
+	}
+	if len(caches) <= BlameCacheSize {
+		return nil
+	}
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].ModTime().Before(caches[j].ModTime())
+	})
+	for _, info := range caches[:len(caches)-BlameCacheSize] {
+		err = os.Remove(filepath.Join(cacheDir, info.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
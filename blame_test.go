@@ -0,0 +1,89 @@
+package gitauto
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commitAs 以指定作者提交文件
+func commitAs(t *testing.T, rc *Repository, email string, files map[string]string) (hash plumbing.Hash) {
+	w, err := rc._r.Worktree()
+	require.NoError(t, err)
+	for name, content := range files {
+		err = rc.AddReplaceFileToStage(name, []byte(content))
+		require.NoError(t, err)
+		_, err = w.Add(name)
+		require.NoError(t, err)
+	}
+	hash, err = w.Commit("change by "+email, &git.CommitOptions{
+		Author: &object.Signature{Name: email, Email: email, When: time.Now()},
+	})
+	require.NoError(t, err)
+	return hash
+}
+
+func TestBlameAt(t *testing.T) {
+	rc := newLocalRepository(t, map[string]string{"doc/a.md": "l1\nl2\nl3\n"})
+	first, err := rc._r.Head()
+	require.NoError(t, err)
+
+	lines, err := rc.GetLineCodeAuthor("doc/a.md")
+	require.NoError(t, err)
+	require.Len(t, lines, 3)
+	assert.Equal(t, NewAuthor("test", "test@example.com"), lines[2].Author)
+	cacheDir := rc.blameCacheDir("doc/a.md")
+	cacheFile := filepath.Join(cacheDir, first.Hash().String()+".json")
+	require.FileExists(t, cacheFile)
+
+	// 篡改缓存,增量更新应沿用缓存中未修改行的归属
+	var cache blameCache
+	b, err := os.ReadFile(cacheFile)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &cache))
+	cache.Lines[2].Author = Author{Name: "cached", Email: "cached@example.com"}
+	require.NoError(t, writeBlameCache(cacheDir, cache))
+
+	commitAs(t, rc, "alice@example.com", map[string]string{"doc/a.md": "l1\nL2\nl3\nl4\n"})
+	third := commitAs(t, rc, "bob@example.com", map[string]string{"doc/a.md": "l0\nL2\nl3\nl4\n"})
+
+	lines, err = rc.BlameAt("doc/a.md", "HEAD")
+	require.NoError(t, err)
+	texts, authors := make([]string, 0), make([]string, 0)
+	for i, line := range lines {
+		assert.Equal(t, i, line.LinNo)
+		texts = append(texts, line.Text)
+		authors = append(authors, line.Author.ID)
+	}
+	assert.Equal(t, []string{"l0", "L2", "l3", "l4"}, texts)
+	assert.Equal(t, []string{"bob@example.com", "alice@example.com", "cached@example.com", "alice@example.com"}, authors)
+	assert.Equal(t, third.String(), lines[0].Commit)
+	require.FileExists(t, filepath.Join(cacheDir, third.String()+".json"))
+
+	t.Run("older revision", func(t *testing.T) {
+		lines, err := rc.BlameAt("doc/a.md", first.Hash().String())
+		require.NoError(t, err)
+		require.Len(t, lines, 3)
+		assert.Equal(t, "l2", lines[1].Text)
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		size := BlameCacheSize
+		BlameCacheSize = 1
+		defer func() { BlameCacheSize = size }()
+		commitAs(t, rc, "carol@example.com", map[string]string{"doc/a.md": "l0\n"})
+		_, err := rc.GetLineCodeAuthor("doc/a.md")
+		require.NoError(t, err)
+		entries, err := os.ReadDir(cacheDir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+}
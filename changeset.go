@@ -0,0 +1,457 @@
+package gitauto
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+)
+
+type changeKind int
+
+const (
+	changeWrite changeKind = iota
+	changeDelete
+	changeRename
+)
+
+type change struct {
+	kind     changeKind
+	filename string
+	to       string
+	content  []byte
+}
+
+// ErrChangeSetClosed 变更集已提交或回滚,不能再次使用
+var ErrChangeSetClosed = errors.New("change set already committed or rolled back")
+
+// original 变更集首次修改文件前,文件在工作区、暂存区中的状态,回滚时恢复
+type original struct {
+	content []byte       // 工作区内容,nil 表示文件不存在
+	entry   *index.Entry // 暂存区条目,nil 表示未跟踪
+}
+
+// ChangeSet 收集一批文件的写入、删除、重命名,Commit 时一次性应用并提交;
+// 从创建到 Commit、Propose 或 Rollback 持有仓库锁,失败或 Rollback 时只恢复变更集修改过的文件
+type ChangeSet struct {
+	rc        *Repository
+	head      plumbing.Hash
+	changes   []change
+	originals map[string]original // 变更集修改过的文件 => 修改前的状态
+	touched   map[string]struct{} // 创建时已被程序写入的文件,回滚时保留写入记录
+	closed    bool
+}
+
+// NewChangeSet 以当前HEAD为基准创建变更集并获取仓库锁,直到 Commit、Propose 或 Rollback 时释放
+func (rc *Repository) NewChangeSet(ctx context.Context) (cs *ChangeSet, err error) {
+	err = rc.Lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	headRef, err := rc._r.Head()
+	if err != nil {
+		_ = rc.Unlock()
+		return nil, err
+	}
+	cs = &ChangeSet{
+		rc:        rc,
+		head:      headRef.Hash(),
+		changes:   make([]change, 0),
+		originals: make(map[string]original),
+		touched:   rc.touchedPaths(),
+	}
+	return cs, nil
+}
+
+// Write 新增或重置文件内容
+func (cs *ChangeSet) Write(remoteFilename string, content []byte) *ChangeSet {
+	cs.changes = append(cs.changes, change{kind: changeWrite, filename: remoteFilename, content: content})
+	return cs
+}
+
+// Delete 删除文件
+func (cs *ChangeSet) Delete(remoteFilenames ...string) *ChangeSet {
+	for _, remoteFilename := range remoteFilenames {
+		cs.changes = append(cs.changes, change{kind: changeDelete, filename: remoteFilename})
+	}
+	return cs
+}
+
+// Rename 重命名文件
+func (cs *ChangeSet) Rename(fromRemoteFilename string, toRemoteFilename string) *ChangeSet {
+	cs.changes = append(cs.changes, change{kind: changeRename, filename: fromRemoteFilename, to: toRemoteFilename})
+	return cs
+}
+
+// Len 变更数量
+func (cs *ChangeSet) Len() int {
+	return len(cs.changes)
+}
+
+// Commit 应用全部变更后只提交变更集涉及的文件并推送,创建前写入(AddReplaceFileToStage 等)或暂存的其它文件不进入提交,保留在工作区;
+// 任一步骤失败都会回滚变更集修改过的文件;完成后释放仓库锁
+func (cs *ChangeSet) Commit(ctx context.Context, commitMsg string, user User) (err error) {
+	return cs.finish(func() (err error) {
+		err = cs.apply()
+		if err != nil {
+			return err
+		}
+		return cs.commit(ctx, commitMsg, user)
+	})
+}
+
+// Propose 应用全部变更后同 Repository.Propose 提交到新分支并创建合并请求,失败时回滚;完成后释放仓库锁
+func (cs *ChangeSet) Propose(ctx context.Context, opts ProposeOptions) (result *PullRequestResult, err error) {
+	err = cs.finish(func() (err error) {
+		err = cs.apply()
+		if err != nil {
+			return err
+		}
+		result, err = cs.rc.propose(ctx, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Rollback 丢弃变更,恢复变更集修改过的文件并释放仓库锁,工作区其它文件不受影响
+func (cs *ChangeSet) Rollback() (err error) {
+	if cs.closed {
+		return ErrChangeSetClosed
+	}
+	cs.closed = true
+	defer func() {
+		unlockErr := cs.rc.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	return cs.rollback()
+}
+
+// finish 执行fn,失败时回滚,最后释放仓库锁
+func (cs *ChangeSet) finish(fn func() error) (err error) {
+	if cs.closed {
+		return ErrChangeSetClosed
+	}
+	cs.closed = true
+	defer func() {
+		unlockErr := cs.rc.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	err = fn()
+	if err != nil {
+		rollbackErr := cs.rollback()
+		if rollbackErr != nil {
+			cs.rc._client.logf("gitauto: rollback change set in %s: %v", cs.rc._workDir, rollbackErr)
+		}
+		return err
+	}
+	cs.changes = cs.changes[:0]
+	return nil
+}
+
+// commit 只提交变更集修改过的文件并推送,其它文件的写入记录保留
+func (cs *ChangeSet) commit(ctx context.Context, commitMsg string, user User) (err error) {
+	rc := cs.rc
+	paths := make([]string, 0, len(cs.originals))
+	for filename := range cs.originals {
+		paths = append(paths, filename)
+	}
+	sort.Strings(paths)
+	if len(paths) == 0 {
+		return nil
+	}
+	committed, err := rc.commitOnly(commitMsg, user, paths)
+	if err != nil {
+		return err
+	}
+	for filename := range cs.touched { // commit 清空了写入记录,未提交的重新加入
+		if _, ok := cs.originals[filename]; !ok {
+			rc.touch(filename)
+		}
+	}
+	if !committed {
+		return nil
+	}
+	return rc.push(ctx)
+}
+
+// commitOnly 只提交 paths,暂存区中其它文件的变更在提交时退回 HEAD 中的状态,提交后原样恢复
+func (rc *Repository) commitOnly(commitMsg string, user User, paths []string) (committed bool, err error) {
+	r := rc._r
+	w, err := r.Worktree()
+	if err != nil {
+		return false, err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return false, err
+	}
+	headRef, err := r.Head()
+	if err != nil {
+		return false, err
+	}
+	headCommit, err := r.CommitObject(headRef.Hash())
+	if err != nil {
+		return false, err
+	}
+	only := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		only[path] = true
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return false, err
+	}
+	staged := make(map[string]original) // 其它已暂存文件 => 暂存区条目,entry 为 nil 表示暂存了删除
+	for path, fileStatus := range status {
+		if only[path] || fileStatus.Staging == git.Unmodified || fileStatus.Staging == git.Untracked {
+			continue
+		}
+		var o original
+		entry, err := idx.Entry(path)
+		if err == nil {
+			copied := *entry
+			o.entry = &copied
+		} else if !errors.Is(err, index.ErrEntryNotFound) {
+			return false, err
+		}
+		staged[path] = o
+		head := original{}
+		f, err := headCommit.File(path)
+		if err == nil {
+			head.entry = &index.Entry{Name: path, Hash: f.Hash, Mode: f.Mode}
+		} else if !errors.Is(err, object.ErrFileNotFound) {
+			return false, err
+		}
+		err = restoreEntry(idx, path, head)
+		if err != nil {
+			return false, err
+		}
+	}
+	if len(staged) > 0 {
+		err = r.Storer.SetIndex(idx)
+		if err != nil {
+			return false, err
+		}
+		defer func() {
+			restoreErr := rc.restoreEntries(staged)
+			if err == nil {
+				err = restoreErr
+			}
+		}()
+	}
+	return rc.commit(commitMsg, user, paths)
+}
+
+// restoreEntries 把暂存区条目恢复为 entries 中的状态
+func (rc *Repository) restoreEntries(entries map[string]original) (err error) {
+	idx, err := rc._r.Storer.Index()
+	if err != nil {
+		return err
+	}
+	for path, o := range entries {
+		err = restoreEntry(idx, path, o)
+		if err != nil {
+			return err
+		}
+	}
+	return rc._r.Storer.SetIndex(idx)
+}
+
+func (cs *ChangeSet) apply() (err error) {
+	rc := cs.rc
+	w, err := rc._r.Worktree()
+	if err != nil {
+		return err
+	}
+	for _, c := range cs.changes {
+		switch c.kind {
+		case changeWrite:
+			filename := rc.repositoryFilename(c.filename)
+			err = cs.record(w, filename)
+			if err == nil {
+				err = rc.addReplaceFileToStage(filename, c.content)
+			}
+		case changeDelete:
+			filename := rc.repositoryFilename(c.filename)
+			err = cs.record(w, filename)
+			if err == nil {
+				err = rc.deleteFile(filename)
+			}
+		case changeRename:
+			from, to := rc.repositoryFilename(c.filename), rc.repositoryFilename(c.to)
+			err = cs.record(w, from, to)
+			if err == nil {
+				err = w.Filesystem.Rename(from, to)
+				rc.touch(from, to)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record 首次修改文件前保存其在工作区、暂存区中的状态
+func (cs *ChangeSet) record(w *git.Worktree, filenames ...string) (err error) {
+	idx, err := cs.rc._r.Storer.Index()
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		if _, ok := cs.originals[filename]; ok {
+			continue
+		}
+		var o original
+		o.content, err = util.ReadFile(w.Filesystem, filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		entry, err := idx.Entry(filename)
+		if err == nil {
+			copied := *entry
+			o.entry = &copied
+		} else if !errors.Is(err, index.ErrEntryNotFound) {
+			return err
+		}
+		cs.originals[filename] = o
+	}
+	return nil
+}
+
+// rollback 恢复变更集修改过的文件;已提交(如推送失败)时HEAD退回创建时的提交,提交带入的其它文件恢复为该提交中的内容
+func (cs *ChangeSet) rollback() (err error) {
+	rc := cs.rc
+	cs.changes = cs.changes[:0]
+	w, err := rc._r.Worktree()
+	if err != nil {
+		return err
+	}
+	restores := make(map[string]original, len(cs.originals))
+	for filename, o := range cs.originals {
+		restores[filename] = o
+	}
+	headRef, err := rc._r.Head()
+	if err != nil {
+		return err
+	}
+	if headRef.Hash() != cs.head {
+		committed, err := cs.committedOriginals(headRef.Hash())
+		if err != nil {
+			return err
+		}
+		for filename, o := range committed {
+			if _, ok := restores[filename]; !ok {
+				restores[filename] = o
+			}
+		}
+		err = w.Reset(&git.ResetOptions{Commit: cs.head, Mode: git.SoftReset})
+		if err != nil {
+			return err
+		}
+	}
+	idx, err := rc._r.Storer.Index()
+	if err != nil {
+		return err
+	}
+	filenames := make([]string, 0, len(cs.originals))
+	for filename, o := range restores {
+		err = restoreOriginal(w, idx, filename, o)
+		if err != nil {
+			return err
+		}
+		if _, ok := cs.originals[filename]; ok {
+			filenames = append(filenames, filename)
+		}
+	}
+	err = rc._r.Storer.SetIndex(idx)
+	if err != nil {
+		return err
+	}
+	// 只移除变更集带来的写入记录,提交时清空的原有记录重新加入
+	rc.untouch(filenames...)
+	for filename := range cs.touched {
+		rc.touch(filename)
+	}
+	cs.originals = make(map[string]original)
+	return nil
+}
+
+// committedOriginals 创建时的提交到 head 之间修改过的文件在创建时提交中的状态
+func (cs *ChangeSet) committedOriginals(head plumbing.Hash) (originals map[string]original, err error) {
+	r := cs.rc._r
+	base, err := r.CommitObject(cs.head)
+	if err != nil {
+		return nil, err
+	}
+	current, err := r.CommitObject(head)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := changedFiles(base, current)
+	if err != nil {
+		return nil, err
+	}
+	originals = make(map[string]original, len(changed))
+	for filename := range changed {
+		f, err := base.File(filename)
+		if errors.Is(err, object.ErrFileNotFound) {
+			originals[filename] = original{}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return nil, err
+		}
+		originals[filename] = original{
+			content: []byte(content),
+			entry:   &index.Entry{Name: filename, Hash: f.Hash, Mode: f.Mode},
+		}
+	}
+	return originals, nil
+}
+
+// restoreOriginal 把文件在工作区、暂存区中的状态恢复为 o
+func restoreOriginal(w *git.Worktree, idx *index.Index, filename string, o original) (err error) {
+	if o.content == nil {
+		err = w.Filesystem.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		err = util.WriteFile(w.Filesystem, filename, o.content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return restoreEntry(idx, filename, o)
+}
+
+// restoreEntry 把文件的暂存区条目恢复为 o.entry,为 nil 时移除
+func restoreEntry(idx *index.Index, filename string, o original) (err error) {
+	_, err = idx.Remove(filename)
+	if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+		return err
+	}
+	if o.entry != nil {
+		entry := *o.entry
+		idx.Entries = append(idx.Entries, &entry)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package gitauto
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+// newLocalRepository 在临时目录初始化仓库并提交files,不依赖远程仓库
+func newLocalRepository(t *testing.T, files map[string]string) (rc *Repository) {
+	workDir := t.TempDir()
+	r, err := git.PlainInit(workDir, false)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	for name, content := range files {
+		filename := filepath.Join(workDir, name)
+		err = os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(filename, []byte(content), 0644)
+		require.NoError(t, err)
+		_, err = w.Add(name)
+		require.NoError(t, err)
+	}
+	_, err = w.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	rc, err = NewClient().openRepository(context.Background(), workDir, t.TempDir(), workDir, CloneOptions{})
+	require.NoError(t, err)
+	return rc
+}
+
+func TestChangeSetRollback(t *testing.T) {
+	rc := newLocalRepository(t, map[string]string{"doc/a.md": "a", "doc/b.md": "b", "doc/d.md": "d"})
+	// 变更集之外的未提交修改,回滚后保留
+	err := os.WriteFile(filepath.Join(rc._workDir, "doc/d.md"), []byte("d changed"), 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(rc._workDir, "stray.txt"), []byte("stray"), 0644)
+	require.NoError(t, err)
+	err = rc.AddReplaceFileToStage("doc/e.md", []byte("e"))
+	require.NoError(t, err)
+
+	cs, err := rc.NewChangeSet(context.Background())
+	require.NoError(t, err)
+	cs.Write("doc/a.md", []byte("changed")).Write("doc/c.md", []byte("c")).Delete("doc/b.md")
+	err = cs.apply()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = rc.Lock(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded, "change set holds the lock until rollback")
+
+	err = cs.Rollback()
+	require.NoError(t, err)
+	require.ErrorIs(t, cs.Rollback(), ErrChangeSetClosed)
+	w, err := rc._r.Worktree()
+	require.NoError(t, err)
+	status, err := w.Status()
+	require.NoError(t, err)
+	require.Equal(t, []string{"doc/d.md", "doc/e.md", "stray.txt"}, statusPaths(status), status.String())
+	for name, content := range map[string]string{"doc/a.md": "a", "doc/b.md": "b", "doc/d.md": "d changed", "stray.txt": "stray"} {
+		b, err := rc.ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, content, string(b))
+	}
+	require.Equal(t, map[string]struct{}{"doc/e.md": {}}, rc.touchedPaths())
+
+	err = rc.Lock(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, rc.Unlock())
+
+	t.Run("rename", func(t *testing.T) {
+		cs, err := rc.NewChangeSet(context.Background())
+		require.NoError(t, err)
+		cs.Rename("doc/a.md", "doc/renamed.md")
+		err = cs.apply()
+		require.NoError(t, err)
+		_, err = rc.ReadFile("doc/a.md")
+		require.True(t, os.IsNotExist(err))
+		err = cs.Rollback()
+		require.NoError(t, err)
+		b, err := rc.ReadFile("doc/a.md")
+		require.NoError(t, err)
+		require.Equal(t, "a", string(b), "source restored")
+		_, err = rc.ReadFile("doc/renamed.md")
+		require.True(t, os.IsNotExist(err), "target removed")
+		status, err := w.Status()
+		require.NoError(t, err)
+		require.Equal(t, []string{"doc/d.md", "doc/e.md", "stray.txt"}, statusPaths(status), status.String())
+	})
+}
+
+func TestChangeSetCommit(t *testing.T) {
+	remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a", "doc/b.md": "b"})
+	rc := cloneRemote(t, remotePath)
+	user := User{Name: "robot", Email: "robot@example.com"}
+
+	t.Run("commit", func(t *testing.T) {
+		cs, err := rc.NewChangeSet(context.Background())
+		require.NoError(t, err)
+		cs.Write("doc/a.md", []byte("changed")).Write("doc/c.md", []byte("c")).Delete("doc/b.md")
+		err = cs.Commit(context.Background(), "change set", user)
+		require.NoError(t, err)
+		require.ErrorIs(t, cs.Commit(context.Background(), "again", user), ErrChangeSetClosed)
+
+		other := cloneRemote(t, remotePath)
+		head, err := other._r.Head()
+		require.NoError(t, err)
+		commit, err := other._r.CommitObject(head.Hash())
+		require.NoError(t, err)
+		require.Equal(t, "change set", commit.Message)
+		b, err := other.ReadFile("doc/a.md")
+		require.NoError(t, err)
+		require.Equal(t, "changed", string(b))
+		_, err = other.ReadFile("doc/b.md")
+		require.True(t, os.IsNotExist(err))
+		require.Empty(t, rc.touchedPaths())
+	})
+
+	t.Run("push failed", func(t *testing.T) {
+		head, err := rc._r.Head()
+		require.NoError(t, err)
+		cs, err := rc.NewChangeSet(context.Background())
+		require.NoError(t, err)
+		cs.Write("doc/a.md", []byte("again"))
+		rc.RemoteName = "missing"
+		err = cs.Commit(context.Background(), "push failed", user)
+		rc.RemoteName = "origin"
+		require.Error(t, err)
+
+		after, err := rc._r.Head()
+		require.NoError(t, err)
+		require.Equal(t, head.Hash(), after.Hash(), "local commit is undone")
+		w, err := rc._r.Worktree()
+		require.NoError(t, err)
+		status, err := w.Status()
+		require.NoError(t, err)
+		require.True(t, status.IsClean(), status.String())
+		b, err := rc.ReadFile("doc/a.md")
+		require.NoError(t, err)
+		require.Equal(t, "changed", string(b))
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		cs, err := rc.NewChangeSet(context.Background())
+		require.NoError(t, err)
+		cs.Rename("doc/c.md", "doc/r.md")
+		err = cs.Commit(context.Background(), "rename", user)
+		require.NoError(t, err)
+		commit := remoteHeadCommit(t, remotePath)
+		require.Equal(t, "rename", commit.Message)
+		require.Equal(t, []string{"doc/c.md", "doc/r.md"}, commitPaths(t, commit), "rename commits as delete plus add")
+		_, err = commit.File("doc/c.md")
+		require.ErrorIs(t, err, object.ErrFileNotFound)
+		f, err := commit.File("doc/r.md")
+		require.NoError(t, err)
+		content, err := f.Contents()
+		require.NoError(t, err)
+		require.Equal(t, "c", content)
+	})
+
+	t.Run("only own paths", func(t *testing.T) {
+		// 创建前写入、暂存的文件不进入变更集的提交
+		err := rc.AddReplaceFileToStage("doc/x.md", []byte("x"))
+		require.NoError(t, err)
+		err = rc.DeleteFile("doc/r.md")
+		require.NoError(t, err)
+		cs, err := rc.NewChangeSet(context.Background())
+		require.NoError(t, err)
+		cs.Write("doc/a.md", []byte("own"))
+		err = cs.Commit(context.Background(), "own", user)
+		require.NoError(t, err)
+		commit := remoteHeadCommit(t, remotePath)
+		require.Equal(t, "own", commit.Message)
+		require.Equal(t, []string{"doc/a.md"}, commitPaths(t, commit))
+
+		w, err := rc._r.Worktree()
+		require.NoError(t, err)
+		status, err := w.Status()
+		require.NoError(t, err)
+		require.Equal(t, []string{"doc/r.md", "doc/x.md"}, statusPaths(status))
+		require.Equal(t, git.Deleted, status.File("doc/r.md").Staging, "staged deletion is kept")
+		require.Equal(t, map[string]struct{}{"doc/x.md": {}, "doc/r.md": {}}, rc.touchedPaths())
+	})
+}
+
+// remoteHeadCommit 裸仓库 master 分支的最新提交
+func remoteHeadCommit(t *testing.T, remotePath string) (commit *object.Commit) {
+	r, err := git.PlainOpen(remotePath)
+	require.NoError(t, err)
+	commit, err = r.CommitObject(remoteBranchHash(t, remotePath, "master"))
+	require.NoError(t, err)
+	return commit
+}
+
+// commitPaths 提交相对第一父提交修改的文件,按名称排序
+func commitPaths(t *testing.T, commit *object.Commit) (paths []string) {
+	parent, err := commit.Parent(0)
+	require.NoError(t, err)
+	parentTree, err := parent.Tree()
+	require.NoError(t, err)
+	tree, err := commit.Tree()
+	require.NoError(t, err)
+	changes, err := object.DiffTree(parentTree, tree)
+	require.NoError(t, err)
+	paths = make([]string, 0, len(changes))
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" { // 删除
+			name = change.From.Name
+		}
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// statusPaths 有变更的文件,按名称排序
+func statusPaths(status git.Status) (paths []string) {
+	paths = make([]string, 0, len(status))
+	for path := range status {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestCommitTouchedWithPushUnrelated(t *testing.T) {
+	rc := newLocalRepository(t, map[string]string{"doc/a.md": "a"})
+	err := rc.AddReplaceFileToStage("doc/a.md", []byte("changed"))
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(rc._workDir, "stray.txt"), []byte("stray"), 0644)
+	require.NoError(t, err)
+
+	err = rc.CommitTouchedWithPush("touched only", User{Name: "robot", Email: "robot@example.com"})
+	var unrelatedErr *UnrelatedChangesError
+	require.ErrorAs(t, err, &unrelatedErr)
+	require.Equal(t, []string{"stray.txt"}, unrelatedErr.Paths)
+}
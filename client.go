@@ -0,0 +1,133 @@
+package gitauto
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Logger 日志接口,*log.Logger 即满足
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Clock 时钟接口,用于提交时间、分支名等,测试时可替换
+type Clock interface {
+	Now() time.Time
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// DefaultAllowPullPeriod 同一仓库两次pull的最小间隔
+const DefaultAllowPullPeriod = 5 * time.Minute
+
+// Client 持有验证配置、pull限流、工作目录、日志和时钟,不同 Client 之间互不影响;
+// 包级函数(NewRepository、ReadFile、RegisterAuth 等)使用 DefaultClient
+type Client struct {
+	workDir             WorkDirConfig
+	allowPullPeriod     time.Duration // 为0时使用 AllowPullPeriod,仍为0时使用 DefaultAllowPullPeriod
+	logger              Logger
+	clock               Clock
+	recoveryPolicy      RecoveryPolicy
+	auth                *authContainer           // RegisterAuth 注册的验证配置
+	credentialProviders []CredentialProvider     // RegisterAuth 注册的配置之后查询的提供者,见 WithCredentialProviders
+	commentSyntaxes     map[string]CommentSyntax // 扩展名 => 注释语法,未配置的使用 DefaultCommentSyntaxes
+	robots              []Author                 // 程序身份,见 WithRobots
+	pullLimiter         sync.Map                 // 工作目录 => *rate.Limiter
+	repositories        sync.Map                 // 工作目录 => *repositoryEntry
+}
+
+// ClientOption NewClient 可选配置
+type ClientOption func(c *Client)
+
+// WithClientWorkDir 设置 Client 的工作目录配置,为空时使用 RobotWorkDir 和 HostPathLayout
+func WithClientWorkDir(workDir WorkDirConfig) ClientOption {
+	return func(c *Client) {
+		c.workDir = workDir
+	}
+}
+
+// WithAllowPullPeriod 设置同一仓库两次pull的最小间隔
+func WithAllowPullPeriod(period time.Duration) ClientOption {
+	return func(c *Client) {
+		c.allowPullPeriod = period
+	}
+}
+
+// WithLogger 设置日志,默认不输出
+func WithLogger(logger Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithClock 设置时钟,默认使用系统时间
+func WithClock(clock Clock) ClientOption {
+	return func(c *Client) {
+		c.clock = clock
+	}
+}
+
+// NewClient 新建 Client,验证配置为空,需通过 Client.RegisterAuth 注册
+func NewClient(opts ...ClientOption) (c *Client) {
+	c = &Client{
+		logger: nopLogger{},
+		clock:  systemClock{},
+		auth:   &authContainer{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// DefaultClient 包级函数使用的 Client,工作目录、pull间隔沿用 RobotWorkDir、AllowPullPeriod
+var DefaultClient = &Client{
+	logger: nopLogger{},
+	clock:  systemClock{},
+	auth:   &_authContainer,
+}
+
+// WorkDir 仓库地址对应的工作目录
+func (c *Client) WorkDir(remoteFilename string) (path string) {
+	return c.workDir.WorkDir(remoteFilename)
+}
+
+func (c *Client) now() time.Time {
+	return c.clock.Now()
+}
+
+func (c *Client) logf(format string, v ...interface{}) {
+	c.logger.Printf(format, v...)
+}
+
+func (c *Client) getRepositoryEntry(key string) (entry *repositoryEntry) {
+	actual, _ := c.repositories.LoadOrStore(key, &repositoryEntry{})
+	entry = actual.(*repositoryEntry)
+	return entry
+}
+
+// allowPull 此刻是否容许pull
+func (c *Client) allowPull(repositoryPath string) (allow bool) {
+	period := c.allowPullPeriod
+	if period == 0 {
+		period = AllowPullPeriod
+	}
+	if period == 0 {
+		period = DefaultAllowPullPeriod
+	}
+	newRateLimiter := rate.NewLimiter(rate.Every(period), 1) //period内最多更新1次
+	actual, _ := c.pullLimiter.LoadOrStore(repositoryPath, newRateLimiter)
+	rateLimiter := actual.(*rate.Limiter)
+	allow = rateLimiter.Allow()
+	return allow
+}
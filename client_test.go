@@ -0,0 +1,68 @@
+package gitauto
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+type bufferLogger struct {
+	strings.Builder
+}
+
+func (l *bufferLogger) Printf(format string, v ...interface{}) {
+	l.WriteString(format + "\n")
+}
+
+func TestClient(t *testing.T) {
+	remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a"})
+	when := time.Date(2023, 3, 1, 15, 4, 5, 0, time.Local)
+	logger := &bufferLogger{}
+	c1 := NewClient(WithClientWorkDir(WorkDirConfig{Root: t.TempDir()}), WithClock(fixedClock(when)), WithLogger(logger))
+	c2 := NewClient(WithClientWorkDir(WorkDirConfig{Root: t.TempDir()}))
+
+	auth := &http.BasicAuth{Username: "robot", Password: "secret"}
+	c1.RegisterAuth("robot", "gitea.example.com", auth)
+	got, ok := c1.GetAuth("robot", "gitea.example.com")
+	require.True(t, ok)
+	assert.Equal(t, auth, got)
+	_, ok = c2.GetAuth("robot", "gitea.example.com")
+	assert.False(t, ok, "auth must not leak between clients")
+	_, ok = GetAuth("robot", "gitea.example.com")
+	assert.False(t, ok, "auth must not leak into DefaultClient")
+
+	b, err := c1.ReadFile(remotePath + "/doc/a.md")
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(b))
+	assert.Contains(t, logger.String(), "clone")
+
+	rc1, err := c1.NewRepositoryContext(context.Background(), remotePath)
+	require.NoError(t, err)
+	rc2, err := c2.NewRepositoryContext(context.Background(), remotePath)
+	require.NoError(t, err)
+	assert.NotSame(t, rc1, rc2)
+	assert.Equal(t, c1.WorkDir(remotePath), rc1._workDir)
+	assert.Equal(t, c2.WorkDir(remotePath), rc2._workDir)
+
+	err = rc1.AddReplaceFileToStage("doc/a.md", []byte("a1"))
+	require.NoError(t, err)
+	rc1.PushRetry = PushRetry{}
+	err = rc1.CommitWithPush("a1", User{Name: "robot", Email: "robot@example.com"})
+	require.NoError(t, err)
+	head, err := rc1._r.Head()
+	require.NoError(t, err)
+	commit, err := rc1._r.CommitObject(head.Hash())
+	require.NoError(t, err)
+	assert.True(t, when.Equal(commit.Author.When), "commit time comes from client clock")
+}
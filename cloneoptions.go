@@ -0,0 +1,213 @@
+package gitauto
+
+import (
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/errors"
+)
+
+// ErrSparseReadOnly 稀疏检出的工作区缺少未检出的文件,不能提交
+var ErrSparseReadOnly = errors.New("sparse checkout repository is read only")
+
+// sparseConfigSection 稀疏检出目录记录在仓库配置 [gitauto] sparse 中,重新打开仓库时沿用
+const (
+	sparseConfigSection = "gitauto"
+	sparseConfigOption  = "sparse"
+)
+
+// CloneOptions clone 选项,仅在仓库不存在需要clone时生效
+type CloneOptions struct {
+	Depth         int      // 浅克隆深度,0 为完整历史;浅克隆的仓库无法对早于该深度的提交做 blame
+	SingleBranch  bool     // 只clone ReferenceName 指定的分支
+	ReferenceName string   // 分支名(如 master)或完整引用名(如 refs/tags/v1.2.0),为空时为远程默认分支
+	Paths         []string // 只检出这些目录(稀疏检出),为空时检出全部文件;稀疏检出的仓库只读
+	AutoPaths     bool     // ReadFile 时 Paths 为空则自动只检出所读文件所在目录,之后读取其它目录时加入检出目录
+}
+
+// WithCloneOptions 设置clone选项
+func WithCloneOptions(co CloneOptions) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.clone = co
+	}
+}
+
+func (co CloneOptions) referenceName() (name plumbing.ReferenceName) {
+	if co.ReferenceName == "" {
+		return ""
+	}
+	if strings.HasPrefix(co.ReferenceName, "refs/") {
+		return plumbing.ReferenceName(co.ReferenceName)
+	}
+	return plumbing.NewBranchReferenceName(co.ReferenceName)
+}
+
+func (co CloneOptions) gitCloneOptions(remoteUrl string, auth transport.AuthMethod) (cloneOptions *git.CloneOptions) {
+	cloneOptions = &git.CloneOptions{
+		Auth:          auth,
+		URL:           remoteUrl,
+		ReferenceName: co.referenceName(),
+		SingleBranch:  co.SingleBranch,
+		Depth:         co.Depth,
+		NoCheckout:    len(co.Paths) > 0, // clone 后再稀疏检出
+	}
+	return cloneOptions
+}
+
+// sparseCheckout clone 后只检出 Paths 下的文件,并记录到仓库配置
+func (co CloneOptions) sparseCheckout(r *git.Repository) (err error) {
+	if len(co.Paths) == 0 {
+		return nil
+	}
+	paths := co.cleanPaths()
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	err = w.Checkout(&git.CheckoutOptions{
+		Branch:                    head.Name(),
+		SparseCheckoutDirectories: paths,
+	})
+	if err != nil {
+		return err
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Raw.Section(sparseConfigSection).SetOption(sparseConfigOption, strings.Join(paths, ","))
+	err = r.SetConfig(cfg)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (co CloneOptions) cleanPaths() (paths []string) {
+	paths = make([]string, 0, len(co.Paths))
+	for _, p := range co.Paths {
+		p = strings.Trim(path.Clean(strings.ReplaceAll(p, `\`, "/")), "/")
+		if p == "" || p == "." {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// withReadFilePaths AutoPaths 时使用所读文件所在目录作为稀疏检出目录
+func (co CloneOptions) withReadFilePaths(filename string) CloneOptions {
+	if !co.AutoPaths || len(co.Paths) > 0 {
+		return co
+	}
+	dir := path.Dir(filename)
+	if dir == "." || dir == "/" { // 仓库根目录下的文件需要完整检出
+		return co
+	}
+	co.Paths = []string{dir}
+	return co
+}
+
+// sparsePaths 读取仓库配置中记录的稀疏检出目录
+func sparsePaths(r *git.Repository) (paths []string, err error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	value := cfg.Raw.Section(sparseConfigSection).Option(sparseConfigOption)
+	if value == "" {
+		return nil, nil
+	}
+	paths = strings.Split(value, ",")
+	return paths, nil
+}
+
+// widenSparse 稀疏检出的仓库读取未检出目录下的文件时,把该文件所在目录加入检出目录并记录到仓库配置;
+// 仓库根目录下的文件改为完整检出
+func (rc *Repository) widenSparse(filename string) (err error) {
+	filename = strings.Trim(path.Clean(strings.ReplaceAll(filename, `\`, "/")), "/")
+	if len(rc._sparsePaths) == 0 || sparseCovers(rc._sparsePaths, filename) {
+		return nil
+	}
+	var paths []string
+	if dir := path.Dir(filename); dir != "." {
+		paths = append(append(paths, rc._sparsePaths...), dir)
+	}
+	w, err := rc._r.Worktree()
+	if err != nil {
+		return err
+	}
+	// go-git 只会标记不检出的文件,不会取消标记,需要先清除再按新的目录重新检出
+	idx, err := rc._r.Storer.Index()
+	if err != nil {
+		return err
+	}
+	for _, e := range idx.Entries {
+		e.SkipWorktree = false
+	}
+	err = rc._r.Storer.SetIndex(idx)
+	if err != nil {
+		return err
+	}
+	head, err := rc._r.Head()
+	if err != nil {
+		return err
+	}
+	err = w.ResetSparsely(&git.ResetOptions{
+		Commit: head.Hash(),
+		Mode:   git.HardReset,
+	}, paths)
+	if err != nil {
+		return err
+	}
+	cfg, err := rc._r.Config()
+	if err != nil {
+		return err
+	}
+	section := cfg.Raw.Section(sparseConfigSection)
+	if len(paths) == 0 {
+		section.RemoveOption(sparseConfigOption)
+	} else {
+		section.SetOption(sparseConfigOption, strings.Join(paths, ","))
+	}
+	err = rc._r.SetConfig(cfg)
+	if err != nil {
+		return err
+	}
+	rc._client.logf("gitauto: widen sparse checkout of %s to %v", rc._workDir, paths)
+	rc._sparsePaths = paths
+	return nil
+}
+
+// sparseCovers 文件是否在稀疏检出目录下
+func sparseCovers(paths []string, filename string) bool {
+	for _, p := range paths {
+		if strings.HasPrefix(filename, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// pullSparsely 稀疏检出的仓库拉取时只更新检出目录
+func (rc *Repository) pullSparsely(w *git.Worktree) (err error) {
+	remoteRef, err := rc._r.Reference(plumbing.NewRemoteReferenceName(rc.RemoteName, rc.LocalBranch), true)
+	if err != nil {
+		return err
+	}
+	err = w.ResetSparsely(&git.ResetOptions{
+		Commit: remoteRef.Hash(),
+		Mode:   git.HardReset,
+	}, rc._sparsePaths)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package gitauto
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneOptions(t *testing.T) {
+	useRobotWorkDir(t)
+	remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a", "api/b.md": "b", "README.md": "r"})
+
+	t.Run("single branch", func(t *testing.T) {
+		co := CloneOptions{SingleBranch: true, ReferenceName: "master"}
+		b, err := ReadFile(remotePath+"/doc/a.md", WithMemoryStorage(0), WithCloneOptions(co))
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b))
+	})
+
+	t.Run("sparse", func(t *testing.T) {
+		b, err := ReadFile(remotePath+"/doc/a.md", WithCloneOptions(CloneOptions{AutoPaths: true}))
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b))
+		workDir := GetWorkDir(remotePath)
+		_, err = os.Stat(filepath.Join(workDir, "api/b.md"))
+		assert.True(t, os.IsNotExist(err), "file outside sparse paths must not be checked out")
+
+		rc, err := NewRepositoryContext(context.Background(), remotePath)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"doc"}, rc._sparsePaths)
+		err = rc.Pull()
+		require.NoError(t, err)
+		err = rc.AddReplaceFileToStage("doc/c.md", []byte("c"))
+		require.NoError(t, err)
+		err = rc.CommitWithPush("c", User{Name: "robot", Email: "robot@example.com"})
+		require.ErrorIs(t, err, ErrSparseReadOnly)
+	})
+	t.Run("sparse widen", func(t *testing.T) {
+		remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a", "api/b.md": "b", "README.md": "r"})
+		co := WithCloneOptions(CloneOptions{AutoPaths: true})
+		b, err := ReadFile(remotePath+"/doc/a.md", co)
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b))
+		b, err = ReadFile(remotePath+"/api/b.md", co)
+		require.NoError(t, err)
+		assert.Equal(t, "b", string(b))
+		b, err = ReadFile(remotePath+"/doc/a.md", co)
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b))
+		workDir := GetWorkDir(remotePath)
+		_, err = os.Stat(filepath.Join(workDir, "README.md"))
+		assert.True(t, os.IsNotExist(err), "file outside sparse paths must not be checked out")
+
+		rc, err := NewRepositoryContext(context.Background(), remotePath)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"doc", "api"}, rc._sparsePaths)
+		r, err := git.PlainOpen(workDir)
+		require.NoError(t, err)
+		paths, err := sparsePaths(r)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"doc", "api"}, paths)
+
+		b, err = ReadFile(remotePath+"/README.md", co)
+		require.NoError(t, err)
+		assert.Equal(t, "r", string(b))
+		assert.Empty(t, rc._sparsePaths)
+	})
+}
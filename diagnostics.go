@@ -0,0 +1,116 @@
+package gitauto
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/errors"
+)
+
+// AuthAttempt 一次验证配置查询
+type AuthAttempt struct {
+	RemoteURL string // 查询的仓库地址,已去掉密码
+	Provider  string // 提供者,如 static、EnvCredentials
+	Reason    string // 未找到的原因(包含查询的键、文件等),为空表示使用了该提供者返回的验证配置
+}
+
+// AuthDiagnostics 验证配置查询记录,说明为什么使用(或没有使用)验证配置
+type AuthDiagnostics struct {
+	RemoteURL string // getHasAuthRemoteUrlFromRepositoryConfig 选中的仓库地址,已去掉密码
+	Provider  string // 提供验证配置的提供者,为空表示未找到,使用 go-git 默认方式(如 ssh-agent)
+	Attempts  []AuthAttempt
+}
+
+// Found 是否找到验证配置
+func (d AuthDiagnostics) Found() bool {
+	return d.Provider != ""
+}
+
+func (d AuthDiagnostics) String() string {
+	if d.Found() {
+		return fmt.Sprintf("auth for %s from %s", d.RemoteURL, d.Provider)
+	}
+	reasons := make([]string, 0, len(d.Attempts))
+	for _, attempt := range d.Attempts {
+		reasons = append(reasons, fmt.Sprintf("%s %s: %s", attempt.Provider, attempt.RemoteURL, attempt.Reason))
+	}
+	return fmt.Sprintf("no auth for %s, tried: [%s]", d.RemoteURL, strings.Join(reasons, "; "))
+}
+
+// AuthError 网络操作(clone、pull、fetch、push)认证失败,Diagnostics 说明验证配置的查询过程
+type AuthError struct {
+	Err         error
+	Diagnostics AuthDiagnostics
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%v (%s)", e.Err, e.Diagnostics)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// isAuthError 是否为认证失败
+func isAuthError(err error) bool {
+	if errors.Is(err, transport.ErrAuthenticationRequired) || errors.Is(err, transport.ErrAuthorizationFailed) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "unable to authenticate") || strings.Contains(msg, "handshake failed")
+}
+
+// providerName 提供者名称,用于诊断记录
+func providerName(provider CredentialProvider) string {
+	if _, ok := provider.(staticCredentials); ok {
+		return "static"
+	}
+	name := fmt.Sprintf("%T", provider)
+	name = strings.TrimLeft(name, "*")
+	return strings.TrimPrefix(name, "gitauto.")
+}
+
+// attemptReason 去掉 ErrCredentialNotFound 的固定后缀,只保留原因
+func attemptReason(err error) string {
+	return strings.TrimSuffix(err.Error(), ": "+ErrCredentialNotFound.Error())
+}
+
+// AuthDiagnostics 最近一次网络操作的验证配置查询记录
+func (rc *Repository) AuthDiagnostics() (diagnostics AuthDiagnostics) {
+	rc._authMu.Lock()
+	defer rc._authMu.Unlock()
+	if rc._authDiagnostics == nil {
+		return AuthDiagnostics{}
+	}
+	return *rc._authDiagnostics
+}
+
+func (rc *Repository) setAuthDiagnostics(diagnostics *AuthDiagnostics) {
+	rc._authMu.Lock()
+	defer rc._authMu.Unlock()
+	rc._authDiagnostics = diagnostics
+}
+
+// wrapAuthError 网络操作认证失败时附上最近一次的查询记录
+func (rc *Repository) wrapAuthError(err error) error {
+	return wrapAuthError(err, rc.AuthDiagnostics())
+}
+
+// wrapAuthError 认证失败(需要认证、无权限)时附上查询记录,其它错误(如 ctx 取消、网络错误)原样返回
+func wrapAuthError(err error, diagnostics AuthDiagnostics) error {
+	if err == nil {
+		return nil
+	}
+	if diagnostics.RemoteURL == "" {
+		return err
+	}
+	if u, parseErr := url.Parse(diagnostics.RemoteURL); parseErr == nil && u.Scheme == "file" {
+		return err
+	}
+	if !isAuthError(err) {
+		return err
+	}
+	return &AuthError{Err: err, Diagnostics: diagnostics}
+}
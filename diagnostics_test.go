@@ -0,0 +1,93 @@
+package gitauto
+
+import (
+	"context"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthDiagnostics(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(nethttp.StatusUnauthorized)
+	}))
+	defer server.Close()
+	remoteUrl := server.URL + "/org/repo.git"
+
+	remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a"})
+	root := t.TempDir()
+	rc, err := NewClient(WithClientWorkDir(WorkDirConfig{Root: root})).NewRepository(remotePath)
+	require.NoError(t, err)
+	cfg, err := rc._r.Config()
+	require.NoError(t, err)
+	cfg.Remotes["origin"].URLs = []string{remoteUrl}
+	require.NoError(t, rc._r.SetConfig(cfg))
+
+	c := NewClient(WithClientWorkDir(WorkDirConfig{Root: root}), WithCredentialProviders(EnvCredentials{Prefix: "NOT_SET"}))
+	rc, err = c.NewRepository(remotePath)
+	require.NoError(t, err)
+
+	err = rc.Pull()
+	var authErr *AuthError
+	require.ErrorAs(t, err, &authErr)
+	require.ErrorIs(t, err, transport.ErrAuthenticationRequired)
+	diagnostics := rc.AuthDiagnostics()
+	assert.Equal(t, remoteUrl, diagnostics.RemoteURL)
+	assert.False(t, diagnostics.Found())
+	require.Len(t, diagnostics.Attempts, 2)
+	assert.Equal(t, "static", diagnostics.Attempts[0].Provider)
+	assert.Equal(t, "EnvCredentials", diagnostics.Attempts[1].Provider)
+	assert.Contains(t, diagnostics.Attempts[1].Reason, "NOT_SET_PASSWORD")
+	assert.Contains(t, err.Error(), "no auth for "+remoteUrl)
+
+	err = c.RegisterAuthPattern("127.0.0.1", &http.BasicAuth{Username: "robot", Password: "wrong"})
+	require.NoError(t, err)
+	err = rc.Fetch()
+	require.ErrorAs(t, err, &authErr, "authentication failure is wrapped even when auth was found")
+	assert.Equal(t, "static", authErr.Diagnostics.Provider)
+	assert.Equal(t, authErr.Diagnostics, rc.AuthDiagnostics())
+
+	t.Run("clone", func(t *testing.T) {
+		c := NewClient(WithClientWorkDir(WorkDirConfig{Root: t.TempDir()}), WithCredentialProviders(EnvCredentials{Prefix: "NOT_SET"}))
+		_, err := c.NewRepository(remoteUrl)
+		var authErr *AuthError
+		require.ErrorAs(t, err, &authErr)
+		require.ErrorIs(t, err, transport.ErrAuthenticationRequired)
+		assert.Equal(t, remoteUrl, authErr.Diagnostics.RemoteURL)
+		require.Len(t, authErr.Diagnostics.Attempts, 2)
+
+		_, err = c.NewRepositoryContext(canceledContext(), remoteUrl)
+		require.ErrorIs(t, err, context.Canceled)
+		assert.False(t, errors.As(err, &authErr), "cancellation is not an auth error")
+	})
+
+	t.Run("network error", func(t *testing.T) {
+		broken := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			w.WriteHeader(nethttp.StatusInternalServerError)
+		}))
+		defer broken.Close()
+		c := NewClient(WithClientWorkDir(WorkDirConfig{Root: t.TempDir()}), WithCredentialProviders(EnvCredentials{Prefix: "NOT_SET"}))
+		rc, err := c.NewRepository(remotePath)
+		require.NoError(t, err)
+		cfg, err := rc._r.Config()
+		require.NoError(t, err)
+		cfg.Remotes["origin"].URLs = []string{broken.URL + "/org/repo.git"}
+		require.NoError(t, rc._r.SetConfig(cfg))
+		err = rc.Fetch()
+		require.Error(t, err)
+		var authErr *AuthError
+		assert.False(t, errors.As(err, &authErr), "no auth hint for errors unrelated to auth")
+	})
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
@@ -1,18 +1,16 @@
 package gitauto
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"os"
-	"regexp"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing/transport"
-	"golang.org/x/time/rate"
+	"github.com/pkg/errors"
 )
 
 func LocalFilename(remoteFilename string) (localFilename string) {
@@ -36,114 +34,68 @@ func RepositoryUrl(remoteFilename string) (remoteUrl string) {
 	return remoteUrl
 }
 
+// GetWorkDir 默认配置(RobotWorkDir、HostPathLayout)下的工作目录,自定义见 WorkDirConfig
 func GetWorkDir(remoteFilename string) (path string) {
-	remoteUrl, _ := splitRemoteUrlAndRepositoryFilename(remoteFilename)
-	u, err := parseRemoteUrl(remoteUrl)
-	repositoryPath := remoteUrl
-	if err == nil {
-		repositoryPath = relativeWorkDir(u)
-	}
-	repositoryPath = strings.TrimSuffix(repositoryPath, git.GitDirName)
-	path = fmt.Sprintf("%s/%s", RobotWorkDir, repositoryPath)
-	path = strings.TrimRight(path, "/")
-	return path
+	return WorkDirConfig{}.WorkDir(remoteFilename)
 }
 
 //splitRemoteUrlAndRepositoryFilename 从远程文件路径中识别出远程仓库地址和仓库下文件名,如果没有.git 标记，则全部当成filename 返回（批量设置文件内容时，有用到这个特性）
 func splitRemoteUrlAndRepositoryFilename(remoteFilename string) (remoteUrl string, filename string) {
-	gitIndex := strings.Index(remoteFilename, git.GitDirName)
-	if gitIndex < 0 {
-		return "", remoteFilename
-	}
-	index := gitIndex + 4
-	remoteUrl, filename = remoteFilename[:index], remoteFilename[index:]
-	filename = strings.TrimLeft(filename, "/") //仓库内文件，开头不用"/"
+	remoteUrl, _, filename = splitRemoteAddress(remoteFilename)
 	return remoteUrl, filename
 }
 
-// getHasAuthRemoteUrlFromRepositoryConfig 获取仓库远程地址和验证配置,验证配置不存在时,返回最后一条远程地址,验证器返回空
-func getHasAuthRemoteUrlFromRepositoryConfig(cfg *config.Config) (auth transport.AuthMethod, u *url.URL) {
+// splitRemoteAddress 从远程文件地址中识别出远程仓库地址、版本和仓库下文件名,地址格式:
+//
+//	address = repository ".git" [ "@" ref ] [ "/" filename ] [ "#ref=" ref ]
+//
+// 如 git@github.com:org/repo.git@v1.2.0/doc/a.md、ssh://git@host:2221/org/repo.git/doc/a.md#ref=feature/x,
+// "@" 形式的版本不能包含"/",包含"/"的分支名使用 "#ref=" 形式;两种形式同时出现时以 "#ref=" 为准;
+// 不带版本表示当前分支最新内容。没有.git 标记时全部当成filename 返回,解析规则见 ParseRemoteFile
+func splitRemoteAddress(remoteFilename string) (remoteUrl string, ref string, filename string) {
+	rf, err := ParseRemoteFile(remoteFilename)
+	if err != nil {
+		return "", "", remoteFilename
+	}
+	return rf.RepositoryURL(), rf.Ref, rf.FilePath
+}
+
+// getHasAuthRemoteUrlFromRepositoryConfig 获取仓库远程地址和验证配置,验证配置不存在时,返回最后一条远程地址,验证器返回空;
+// diagnostics 记录选中的地址和每个地址、提供者的查询结果
+func (c *Client) getHasAuthRemoteUrlFromRepositoryConfig(ctx context.Context, cfg *config.Config) (auth transport.AuthMethod, u *url.URL, diagnostics *AuthDiagnostics, err error) {
+	diagnostics = &AuthDiagnostics{}
 	for _, remote := range cfg.Remotes {
 		for _, remoteAddress := range remote.URLs {
-			var err error
-			u, err = parseRemoteUrl(remoteAddress)
+			remoteUrl, err := parseRemoteUrl(remoteAddress)
 			if err != nil {
+				diagnostics.Attempts = append(diagnostics.Attempts, AuthAttempt{RemoteURL: remoteAddress, Provider: "config", Reason: err.Error()})
+				continue
+			}
+			u = remoteUrl
+			diagnostics.RemoteURL = redactURL(u)
+			auth, attempts, err := c.credential(ctx, u)
+			diagnostics.Attempts = append(diagnostics.Attempts, attempts...)
+			if errors.Is(err, ErrCredentialNotFound) {
+				c.logf("gitauto: %v", err)
 				continue
 			}
-			auth, ok := GetAuth(u.User.Username(), u.Hostname())
-			if ok {
-				return auth, u
+			if err != nil {
+				return nil, u, diagnostics, err
 			}
+			diagnostics.Provider = attempts[len(attempts)-1].Provider
+			return auth, u, diagnostics, nil
 		}
 	}
-	return nil, u
-}
-
-var pullLimiter sync.Map
-
-// allowPull  此刻是否容许pull
-func allowPull(repositoryPath string) (allow bool) {
-	if AllowPullPeriod == 0 {
-		AllowPullPeriod = 5 * time.Minute
-	}
-	newRateLimiter := rate.NewLimiter(rate.Every(AllowPullPeriod), 1) //5分钟内最多更新1次
-	actual, _ := pullLimiter.LoadOrStore(repositoryPath, newRateLimiter)
-	rateLimiter := actual.(*rate.Limiter)
-	allow = rateLimiter.Allow()
-	return allow
-}
-
-// relativeWorkDir 获取相对工作目录
-func relativeWorkDir(u *url.URL) (repositoryPath string) {
-	repositoryPath = fmt.Sprintf("%s/%s", strings.Trim(u.Hostname(), "/"), strings.Trim(u.Path, "/"))
-	return repositoryPath
+	return nil, u, diagnostics, nil
 }
 
+// parseRemoteUrl 解析仓库地址,scp 形式(git@host:path)转换为 ssh://
 func parseRemoteUrl(remoteUrl string) (u *url.URL, err error) {
-	u, err = url.Parse(remoteUrl)
-	if err == nil {
-		return u, nil
-	}
-	u, err = detectSSH(remoteUrl)
-	if err != nil {
-		return u, err
-	}
-	return u, nil
-}
-
-// Note that we do not have an SSH-getter currently so this file serves
-// only to hold the detectSSH helper that is used by other detectors.
-
-// sshPattern matches SCP-like SSH patterns (user@host:path)
-var sshPattern = regexp.MustCompile("^(?:([^@]+)@)?([^:]+):/?(.+)$")
-
-// detectSSH determines if the src string matches an SSH-like URL and
-// converts it into a net.URL compatible string. This returns nil if the
-// string doesn't match the SSH pattern.
-//
-// This function is tested indirectly via detect_git_test.go
-func detectSSH(src string) (*url.URL, error) {
-	matched := sshPattern.FindStringSubmatch(src)
-	if matched == nil {
-		return nil, nil
-	}
-
-	user := matched[1]
-	host := matched[2]
-	path := matched[3]
-	tmpU, err := url.Parse(path)
+	rf, err := parseRepositoryURL(remoteUrl)
 	if err != nil {
 		return nil, err
 	}
-	var u url.URL
-	u.Scheme = "ssh"
-	u.User = url.User(user)
-	u.Host = host
-	u.Path = tmpU.Path
-	u.RawQuery = tmpU.RawQuery
-	u.Fragment = tmpU.Fragment
-
-	return &u, nil
+	return rf.URL(), nil
 }
 
 func getRepositoryFilenameByLocalFilename(localFilename string) (repositoryFilename string) {
@@ -2,10 +2,14 @@ package gitauto
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -17,56 +21,169 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport"
 )
 
+// RobotWorkDir DefaultClient 及未指定根目录的 WorkDirConfig 使用的工作根目录
 var RobotWorkDir = "/tmp/dml"
+
+// AllowPullPeriod DefaultClient 及未指定pull间隔的 Client 同一仓库两次pull的最小间隔,为0时使用 DefaultAllowPullPeriod
 var AllowPullPeriod time.Duration
 
 type Repository struct {
-	_auth       transport.AuthMethod
-	_r          *git.Repository
-	RemoteName  string
-	LocalBranch string
+	_client          *Client
+	_r               *git.Repository
+	_workDir         string
+	_root            string        // 工作根目录,锁目录、隔离目录所在目录;内存仓库为空
+	_sem             chan struct{} // 进程内互斥锁,容量为1
+	_fileLock        *fileLock     // 跨进程文件锁
+	_touchedMu       sync.Mutex
+	_touched         map[string]struct{} // 程序写入、删除过的仓库内文件
+	_sparsePaths     []string            // 稀疏检出目录,为空表示完整检出
+	_authMu          sync.Mutex
+	_authDiagnostics *AuthDiagnostics // 最近一次网络操作的验证配置查询记录
+	RemoteName       string
+	LocalBranch      string
+	PushRetry        PushRetry
 }
 type User struct {
 	Name  string
 	Email string
 }
 
-func NewRepository(remoteUrl string) (rc *Repository, err error) {
+func NewRepository(remoteUrl string, opts ...RepositoryOption) (rc *Repository, err error) {
+	return DefaultClient.NewRepositoryContext(context.Background(), remoteUrl, opts...)
+}
+
+// NewRepositoryContext 同 NewRepository,使用 DefaultClient
+func NewRepositoryContext(ctx context.Context, remoteUrl string, opts ...RepositoryOption) (rc *Repository, err error) {
+	return DefaultClient.NewRepositoryContext(ctx, remoteUrl, opts...)
+}
+
+// NewRepository 打开仓库,本地不存在时clone
+func (c *Client) NewRepository(remoteUrl string, opts ...RepositoryOption) (rc *Repository, err error) {
+	return c.NewRepositoryContext(context.Background(), remoteUrl, opts...)
+}
+
+// NewRepositoryContext 同 NewRepository,仓库不存在需要clone时,ctx 取消或超时会中断clone。
+// 同一工作目录在同一 Client 内只会打开一次,所有调用方共享同一个 Repository。修改工作区的方法各自持有仓库锁,
+// 写入和提交需要作为一个整体(不被其它调用方的提交、pull 插入)时使用 WithSession 或 NewChangeSet
+func (c *Client) NewRepositoryContext(ctx context.Context, remoteUrl string, opts ...RepositoryOption) (rc *Repository, err error) {
 	if remoteUrl == "" {
 		err = errors.Errorf("getRepository:remoteUrl not empty ")
 		return nil, err
 	}
+	o := newRepositoryOptions(c.workDir, opts...)
+	workDir := o.workDir.WorkDir(remoteUrl)
+	key := workDir
+	if o.memory {
+		key = memoryKeyPrefix + workDir
+	}
+	entry := c.getRepositoryEntry(key)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.rc == nil {
+		if o.memory {
+			rc, err = c.openMemoryRepository(ctx, remoteUrl, key, o.memoryLimit, o.clone)
+		} else {
+			rc, err = c.openRepository(ctx, remoteUrl, o.workDir.RootDir(), workDir, o.clone)
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.rc = rc
+	}
+	if !o.memory {
+		err = markAccess(workDir, c.now())
+		if err != nil {
+			c.logf("gitauto: mark access %s: %v", workDir, err)
+		}
+	}
+	return entry.rc, nil
+}
+
+// openRepository 打开工作目录下的仓库,不存在或损坏时持有文件锁clone、恢复,避免多个进程同时clone到同一目录
+func (c *Client) openRepository(ctx context.Context, remoteUrl string, root string, workDir string, co CloneOptions) (rc *Repository, err error) {
 	rc = &Repository{
+		_client:    c,
+		_workDir:   workDir,
+		_root:      root,
+		_sem:       make(chan struct{}, 1),
+		_fileLock:  newFileLock(root, workDir),
 		RemoteName: "origin",
+		PushRetry:  DefaultPushRetry,
 	}
-	workDir := GetWorkDir(remoteUrl)
-	rc._r, err = git.PlainOpen(workDir)
-	if errors.Is(err, git.ErrRepositoryNotExists) { // 仓库不存在,clone
-		err = nil
-		rc._r, err = clone(remoteUrl)
+	r, broken := checkRepository(workDir)
+	if r == nil || broken != nil { // 仓库不存在或损坏
+		err = rc._fileLock.Lock(ctx)
 		if err != nil {
 			return nil, err
 		}
+		defer rc._fileLock.Unlock()
+		r, err = c.openOrClone(ctx, remoteUrl, root, workDir, co) // 等待锁期间,其它进程可能已经clone或恢复
+		if err != nil {
+			return nil, err
+		}
+	}
+	rc._r = r
+	err = rc.init()
+	if err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// openMemoryRepository clone远程仓库到内存,仅进程内可见,不需要文件锁
+func (c *Client) openMemoryRepository(ctx context.Context, remoteUrl string, key string, limit int64, co CloneOptions) (rc *Repository, err error) {
+	rc = &Repository{
+		_client:    c,
+		_workDir:   key,
+		_sem:       make(chan struct{}, 1),
+		RemoteName: "origin",
+		PushRetry:  DefaultPushRetry,
+	}
+	c.logf("gitauto: clone %s into memory", remoteUrl)
+	rc._r, err = c.cloneToMemory(ctx, remoteUrl, limit, co)
+	if err != nil {
+		return nil, err
+	}
+	err = rc.init()
+	if err != nil {
+		return nil, err
 	}
+	return rc, nil
+}
+
+// init 读取当前分支、稀疏检出目录
+func (rc *Repository) init() (err error) {
 	// 获取HEAD引用
 	head, err := rc._r.Head()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	rc.LocalBranch = strings.TrimPrefix(head.Name().String(), "refs/heads/")
-	cfg, err := rc._r.Config()
+	rc._sparsePaths, err = sparsePaths(rc._r)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	auth, u := getHasAuthRemoteUrlFromRepositoryConfig(cfg)
-	if auth != nil {
-		rc._auth = auth
-		return rc, nil
+	return nil
+}
+
+// auth 获取远程仓库验证配置,每次网络操作前查询,查询链见 Client.Credential
+func (rc *Repository) auth(ctx context.Context) (auth transport.AuthMethod, err error) {
+	auth, _, err = rc.remoteAuth(ctx)
+	return auth, err
+}
+
+// remoteAuth 获取远程仓库地址和验证配置,并记录查询过程,见 AuthDiagnostics
+func (rc *Repository) remoteAuth(ctx context.Context) (auth transport.AuthMethod, u *url.URL, err error) {
+	cfg, err := rc._r.Config()
+	if err != nil {
+		return nil, nil, err
 	}
-	if u != nil {
-		rc._auth, _ = GetAuth(u.User.Username(), u.Hostname())
+	auth, u, diagnostics, err := rc._client.getHasAuthRemoteUrlFromRepositoryConfig(ctx, cfg)
+	rc.setAuthDiagnostics(diagnostics)
+	if err != nil {
+		return nil, nil, err
 	}
-	return rc, nil
+	return auth, u, nil
 }
 
 func (rc *Repository) ReadFile(filename string) (b []byte, err error) {
@@ -87,7 +204,71 @@ func (rc *Repository) ReadFile(filename string) (b []byte, err error) {
 	return b, nil
 }
 
+// ReadFileAt 读取指定版本的文件内容,rev 支持分支、标签、提交hash(含前缀)及 HEAD~1 等语法,
+// 直接从对象库读取,不依赖也不修改工作区
+func (rc *Repository) ReadFileAt(remoteOrLocalFilename string, rev string) (b []byte, err error) {
+	commit, err := rc.resolveCommit(rev)
+	if err != nil {
+		return nil, err
+	}
+	filename := rc.repositoryFilename(remoteOrLocalFilename)
+	f, err := commit.File(filename)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "%s@%s", filename, rev)
+	}
+	reader, err := f.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	b, err = io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// resolveCommit 解析版本对应的提交;分支名优先使用同名远程分支(fetch 后本地分支可能落后于远程),
+// 没有远程分支时按 git 语法解析本地分支、标签、提交hash及 HEAD~1 等
+func (rc *Repository) resolveCommit(rev string) (commit *object.Commit, err error) {
+	r := rc._r
+	var hash *plumbing.Hash
+	if rev != plumbing.HEAD.String() && !strings.HasPrefix(rev, "refs/") && !strings.ContainsAny(rev, "~^@:") {
+		remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName(rc.RemoteName, rev), true)
+		if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, errors.WithMessagef(err, "resolve revision %s", rev)
+		}
+		if err == nil {
+			remoteHash := remoteRef.Hash()
+			hash = &remoteHash
+		}
+	}
+	if hash == nil {
+		hash, err = r.ResolveRevision(plumbing.Revision(rev))
+	}
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		remoteRev := plumbing.NewRemoteReferenceName(rc.RemoteName, rev).String()
+		hash, err = r.ResolveRevision(plumbing.Revision(remoteRev))
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "resolve revision %s", rev)
+	}
+	commit, err = r.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
 func (rc *Repository) CreateBranch(branchName string) (err error) {
+	return rc.CreateBranchContext(context.Background(), branchName)
+}
+
+// CreateBranchContext 同 CreateBranch,仅操作本地引用,执行前检查ctx是否已取消
+func (rc *Repository) CreateBranchContext(ctx context.Context, branchName string) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	r := rc._r
 	localRef := plumbing.NewBranchReferenceName(branchName)
 	err = r.CreateBranch(&config.Branch{
@@ -123,6 +304,14 @@ func (rc *Repository) CreateBranch(branchName string) (err error) {
 }
 
 func (rc *Repository) Checkout() (err error) {
+	return rc.CheckoutContext(context.Background())
+}
+
+// CheckoutContext 同 Checkout,仅操作本地工作区,执行前检查ctx是否已取消
+func (rc *Repository) CheckoutContext(ctx context.Context) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	w, err := rc._r.Worktree()
 	if err != nil {
 		return err
@@ -138,107 +327,281 @@ func (rc *Repository) Checkout() (err error) {
 }
 
 func (rc *Repository) Pull() (err error) {
+	return rc.PullContext(context.Background())
+}
+
+// PullContext 同 Pull,ctx 取消或超时会中断网络传输,此时工作区保持pull前的状态
+func (rc *Repository) PullContext(ctx context.Context) (err error) {
 	w, err := rc._r.Worktree()
 	if err != nil {
 		return err
 	}
-	err = w.Pull(&git.PullOptions{
-		Auth:  rc._auth,
+	if len(rc._sparsePaths) > 0 {
+		err = rc.FetchContext(ctx)
+		if err != nil {
+			return err
+		}
+		return rc.pullSparsely(w)
+	}
+	auth, err := rc.auth(ctx)
+	if err != nil {
+		return err
+	}
+	err = w.PullContext(ctx, &git.PullOptions{
+		Auth:  auth,
 		Force: true,
 	})
 	if errors.Is(err, git.NoErrAlreadyUpToDate) { //already up-to-date 为正常情况
 		err = nil
 	}
 	if err != nil {
-		return err
+		return rc.wrapAuthError(err)
 	}
 	return
 }
 
-func (rc *Repository) CommitWithPush(commitMsg string, user User) (err error) {
-	if user.Email == "" {
-		err = errors.Errorf("user.Email not be empty")
+// Fetch 更新远程分支、标签引用,不修改工作区
+func (rc *Repository) Fetch() (err error) {
+	return rc.FetchContext(context.Background())
+}
+
+// FetchContext 同 Fetch,ctx 取消或超时会中断网络传输
+func (rc *Repository) FetchContext(ctx context.Context) (err error) {
+	auth, err := rc.auth(ctx)
+	if err != nil {
 		return err
 	}
-	r := rc._r
+	err = rc._r.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: rc.RemoteName,
+		Auth:       auth,
+		Tags:       git.AllTags,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) { //already up-to-date 为正常情况
+		err = nil
+	}
 	if err != nil {
-		return err
+		return rc.wrapAuthError(err)
 	}
-	cfg, err := r.Config()
+	return nil
+}
+
+func (rc *Repository) CommitWithPush(commitMsg string, user User) (err error) {
+	return rc.CommitWithPushContext(context.Background(), commitMsg, user)
+}
+
+// CommitWithPushContext 同 CommitWithPush,ctx 取消或超时会中断pull/push的网络传输;持有仓库锁执行
+func (rc *Repository) CommitWithPushContext(ctx context.Context, commitMsg string, user User) (err error) {
+	return rc.WithLock(ctx, func() error {
+		return rc.commitWithPush(ctx, commitMsg, user)
+	})
+}
+
+func (rc *Repository) commitWithPush(ctx context.Context, commitMsg string, user User) (err error) {
+	committed, err := rc.commit(commitMsg, user, nil)
 	if err != nil {
 		return err
 	}
-	auth, u := getHasAuthRemoteUrlFromRepositoryConfig(cfg)
-	w, err := r.Worktree()
+	if !committed {
+		return nil
+	}
+	return rc.push(ctx)
+}
+
+// CommitTouchedWithPush 只提交通过 AddReplaceFileToStage、DeleteFile 写入的文件,工作区存在其它变更时返回 *UnrelatedChangesError
+func (rc *Repository) CommitTouchedWithPush(commitMsg string, user User) (err error) {
+	return rc.CommitTouchedWithPushContext(context.Background(), commitMsg, user)
+}
+
+// CommitTouchedWithPushContext 同 CommitTouchedWithPush,ctx 取消或超时会中断pull/push的网络传输;持有仓库锁执行
+func (rc *Repository) CommitTouchedWithPushContext(ctx context.Context, commitMsg string, user User) (err error) {
+	return rc.WithLock(ctx, func() error {
+		return rc.commitTouchedWithPush(ctx, commitMsg, user)
+	})
+}
+
+func (rc *Repository) commitTouchedWithPush(ctx context.Context, commitMsg string, user User) (err error) {
+	committed, err := rc.commitTouched(commitMsg, user)
 	if err != nil {
 		return err
 	}
+	if !committed {
+		return nil
+	}
+	return rc.push(ctx)
+}
+
+// commitTouched 只暂存并提交程序写入过的文件
+func (rc *Repository) commitTouched(commitMsg string, user User) (committed bool, err error) {
+	w, err := rc._r.Worktree()
+	if err != nil {
+		return false, err
+	}
+	touched := rc.touchedPaths()
+	err = checkUnrelatedChanges(w, touched)
+	if err != nil {
+		return false, err
+	}
+	paths := make([]string, 0, len(touched))
+	for path := range touched {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return rc.commit(commitMsg, user, paths)
+}
+
+// checkUnrelatedChanges 工作区、暂存区存在 touched 以外的变更时返回 *UnrelatedChangesError
+func checkUnrelatedChanges(w *git.Worktree, touched map[string]struct{}) (err error) {
 	status, err := w.Status()
 	if err != nil {
 		return err
 	}
-	if status.IsClean() {
-		return nil
+	unrelated := make([]string, 0)
+	for path, fileStatus := range status {
+		if fileStatus.Staging == git.Unmodified && fileStatus.Worktree == git.Unmodified {
+			continue
+		}
+		if _, ok := touched[path]; !ok {
+			unrelated = append(unrelated, path)
+		}
 	}
+	if len(unrelated) > 0 {
+		sort.Strings(unrelated)
+		err = &UnrelatedChangesError{Paths: unrelated}
+		return err
+	}
+	return nil
+}
+
+// UnrelatedChangesError 工作区存在非本程序写入的变更
+type UnrelatedChangesError struct {
+	Paths []string
+}
+
+func (e *UnrelatedChangesError) Error() string {
+	return fmt.Sprintf("unrelated changes in worktree: %s", strings.Join(e.Paths, ","))
+}
+
+// ErrDetachedHead HEAD 游离(如 clone 标签、检出提交)时没有可推送的分支,不能提交、推送
+var ErrDetachedHead = errors.New("HEAD is detached, checkout a branch before committing")
 
-	addPath := "."
-	_, err = w.Add(addPath)
+// checkBranch 当前检出的是分支时返回 nil
+func (rc *Repository) checkBranch() (err error) {
+	if rc.LocalBranch == "" || rc.LocalBranch == plumbing.HEAD.String() {
+		return errors.WithMessage(ErrDetachedHead, rc._workDir)
+	}
+	return nil
+}
+
+// commit 提交变更,paths 为空时提交工作区全部变更(git add .),否则只暂存并提交paths
+func (rc *Repository) commit(commitMsg string, user User, paths []string) (committed bool, err error) {
+	if user.Email == "" {
+		err = errors.Errorf("user.Email not be empty")
+		return false, err
+	}
+	err = rc.checkBranch()
 	if err != nil {
-		return err
+		return false, err
+	}
+	if len(rc._sparsePaths) > 0 {
+		return false, ErrSparseReadOnly
+	}
+	w, err := rc._r.Worktree()
+	if err != nil {
+		return false, err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return false, err
+	}
+	if status.IsClean() {
+		return false, nil
+	}
+
+	all := paths == nil
+	if all {
+		paths = []string{"."}
+	}
+	for _, addPath := range paths {
+		_, err = w.Add(addPath)
+		if err != nil {
+			return false, err
+		}
 	}
 
 	_, err = w.Commit(commitMsg, &git.CommitOptions{
-		All: true,
+		All: all,
 		Author: &object.Signature{
 			Name:  user.Name,
 			Email: user.Email,
-			When:  time.Now(),
+			When:  rc._client.now(),
 		},
 	})
+	if errors.Is(err, git.ErrEmptyCommit) { // 写入的内容与HEAD一致
+		return false, nil
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
-	err = w.Pull(&git.PullOptions{
-		Auth:      auth,
-		RemoteURL: u.String(),
-	})
-	if errors.Is(err, git.NoErrAlreadyUpToDate) { //already up-to-date 为正常情况
-		err = nil
+	rc.resetTouched()
+	return true, nil
+}
+
+// touch 记录程序写入过的仓库内文件
+func (rc *Repository) touch(filenames ...string) {
+	rc._touchedMu.Lock()
+	defer rc._touchedMu.Unlock()
+	if rc._touched == nil {
+		rc._touched = make(map[string]struct{})
 	}
-	if errors.Is(err, git.ErrNonFastForwardUpdate) { //ErrNonFastForwardUpdate 为正常情况
-		err = nil
+	for _, filename := range filenames {
+		rc._touched[filename] = struct{}{}
 	}
-	if err != nil {
-		return err
+}
+
+func (rc *Repository) touchedPaths() (touched map[string]struct{}) {
+	rc._touchedMu.Lock()
+	defer rc._touchedMu.Unlock()
+	touched = make(map[string]struct{}, len(rc._touched))
+	for filename := range rc._touched {
+		touched[filename] = struct{}{}
 	}
+	return touched
+}
 
-	branchName := rc.LocalBranch
-	refSpec := config.RefSpec(fmt.Sprintf("refs/heads/%s:refs/heads/%s", branchName, branchName))
+func (rc *Repository) resetTouched() {
+	rc._touchedMu.Lock()
+	defer rc._touchedMu.Unlock()
+	rc._touched = nil
+}
 
-	err = r.Push(&git.PushOptions{
-		Auth:      auth,
-		RemoteURL: u.String(),
-		RefSpecs: []config.RefSpec{
-			refSpec,
-		},
-	})
-	if errors.Is(err, git.NoErrAlreadyUpToDate) {
-		err = nil
+// untouch 移除部分写入记录,如回滚的变更集写入的文件
+func (rc *Repository) untouch(filenames ...string) {
+	rc._touchedMu.Lock()
+	defer rc._touchedMu.Unlock()
+	for _, filename := range filenames {
+		delete(rc._touched, filename)
 	}
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
-// AddReplaceFileToStage 新增、重置文件内容,并执行 git add .
+// AddReplaceFileToStage 新增、重置文件内容,并执行 git add .;
+// 当前文件有保护区域(gitauto:keep begin/end)时,区域内的内容带入新内容,标记不匹配时返回 *KeepMarkerError;持有仓库锁执行
 func (rc *Repository) AddReplaceFileToStage(remoteFilename string, content []byte) (err error) {
+	return rc.WithLock(context.Background(), func() error {
+		return rc.addReplaceFileToStage(remoteFilename, content)
+	})
+}
+
+func (rc *Repository) addReplaceFileToStage(remoteFilename string, content []byte) (err error) {
 	r := rc._r
 	w, err := r.Worktree()
 	if err != nil {
 		return err
 	}
-	filename := RepositoryFilename(remoteFilename)
+	filename := rc.repositoryFilename(remoteFilename)
+	content, err = rc.keepRegions(filename, content)
+	if err != nil {
+		return err
+	}
 	billyFile, err := w.Filesystem.OpenFile(filename, os.O_RDWR|os.O_CREATE, os.ModePerm)
 	if err != nil {
 		return err
@@ -256,25 +619,34 @@ func (rc *Repository) AddReplaceFileToStage(remoteFilename string, content []byt
 	if err != nil {
 		return err
 	}
+	rc.touch(filename)
 	return nil
 }
 
+// DeleteFile 删除文件并暂存删除;持有仓库锁执行
 func (rc *Repository) DeleteFile(remoteFilenames ...string) (err error) {
+	return rc.WithLock(context.Background(), func() error {
+		return rc.deleteFile(remoteFilenames...)
+	})
+}
+
+func (rc *Repository) deleteFile(remoteFilenames ...string) (err error) {
 	r := rc._r
 	w, err := r.Worktree()
 	if err != nil {
 		return err
 	}
 	for _, remoteFilename := range remoteFilenames {
-		filename := RepositoryFilename(remoteFilename)
+		filename := rc.repositoryFilename(remoteFilename)
 		err = w.Filesystem.Remove(filename)
 		if err != nil {
 			return err
 		}
-	}
-	err = rc.AddAll()
-	if err != nil {
-		return err
+		rc.touch(filename)
+		_, err = w.Add(filename) // 只暂存删除的文件,避免把工作区其它变更一并暂存
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -292,77 +664,103 @@ func (rc *Repository) AddAll() (err error) {
 }
 
 func clone(remoteUrl string) (r *git.Repository, err error) {
+	return DefaultClient.cloneContext(context.Background(), remoteUrl, GetWorkDir(remoteUrl), CloneOptions{})
+}
+
+// cloneContext ctx 取消或超时时中断clone,go-git 会清理未完成的工作目录
+func (c *Client) cloneContext(ctx context.Context, remoteUrl string, workDir string, co CloneOptions) (r *git.Repository, err error) {
 	remoteUrl, _ = splitRemoteUrlAndRepositoryFilename(remoteUrl)
 	remoteUrlObj, err := parseRemoteUrl(remoteUrl)
 	if err != nil {
 		return nil, err
 	}
-	auth, _ := GetAuth(remoteUrlObj.User.Username(), remoteUrlObj.Hostname())
-	workDir := GetWorkDir(remoteUrl)
-	cloneOptions := &git.CloneOptions{
-		Auth: auth,
-		URL:  remoteUrl,
+	auth, diagnostics, err := c.resolveAuth(ctx, remoteUrlObj)
+	if err != nil {
+		return nil, err
 	}
-
-	r, err = git.PlainClone(workDir, false, cloneOptions)
+	cloneOptions := co.gitCloneOptions(remoteUrl, auth)
+	r, err = git.PlainCloneContext(ctx, workDir, false, cloneOptions)
+	if err != nil {
+		return nil, wrapAuthError(err, diagnostics)
+	}
+	err = co.sparseCheckout(r)
 	if err != nil {
+		_ = os.RemoveAll(workDir)
 		return nil, err
 	}
 	return r, nil
 }
 
 // ReadFile 获取文件内容 path=ssh://git@gitea.programmerfamily.com:2221/go/coupon.git/doc/advertise/admin/adAdd.md,path=git@github.com:suifengpiao14/apidml/example/doc/addAdd.md
-func ReadFile(remoteFilename string) (b []byte, err error) {
-	remoteUrl, filename := splitRemoteUrlAndRepositoryFilename(remoteFilename)
-	rc, err := NewRepository(remoteUrl)
+// 地址带版本时(path=git@github.com:suifengpiao14/apidml.git@v1.2.0/example/doc/addAdd.md)读取该版本的内容,格式见 splitRemoteAddress
+func ReadFile(remoteFilename string, opts ...RepositoryOption) (b []byte, err error) {
+	return DefaultClient.ReadFileContext(context.Background(), remoteFilename, opts...)
+}
+
+// ReadFileContext 同 ReadFile,使用 DefaultClient
+func ReadFileContext(ctx context.Context, remoteFilename string, opts ...RepositoryOption) (b []byte, err error) {
+	return DefaultClient.ReadFileContext(ctx, remoteFilename, opts...)
+}
+
+// ReadFile 获取远程文件内容,地址格式见 splitRemoteAddress
+func (c *Client) ReadFile(remoteFilename string, opts ...RepositoryOption) (b []byte, err error) {
+	return c.ReadFileContext(context.Background(), remoteFilename, opts...)
+}
+
+// ReadFileContext 同 ReadFile,ctx 取消或超时会中断clone/pull
+func (c *Client) ReadFileContext(ctx context.Context, remoteFilename string, opts ...RepositoryOption) (b []byte, err error) {
+	remoteUrl, ref, filename := splitRemoteAddress(remoteFilename)
+	opts = append(opts, func(o *repositoryOptions) {
+		o.clone = o.clone.withReadFilePaths(filename)
+	})
+	rc, err := c.NewRepositoryContext(ctx, remoteUrl, opts...)
 	if err != nil {
 		return nil, err
 	}
-	workDir := GetWorkDir(remoteUrl)
-	if allowPull(workDir) {
-		err = rc.Checkout()
-		if err != nil {
-			return nil, err
+	workDir := rc._workDir
+	if ref != "" { // 指定版本时从对象库读取,只需更新远程引用,不动工作区
+		if c.allowPull(workDir) {
+			err = rc.WithLock(ctx, func() error {
+				return rc.FetchContext(ctx)
+			})
+			if err != nil {
+				return nil, err
+			}
 		}
-		err = rc.Pull()
+		return rc.ReadFileAt(filename, ref)
+	}
+	err = rc.WithLock(ctx, func() error {
+		if c.allowPull(workDir) {
+			err := rc.CheckoutContext(ctx)
+			if err != nil {
+				return err
+			}
+			err = rc.PullContext(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		err := rc.widenSparse(filename)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		b, err = rc.ReadFile(filename)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return rc.ReadFile(filename)
+	return b, nil
 }
 
-// GetLineCodeAuthor 获取文件每行作者
+// GetLineCodeAuthor 获取文件每行作者,同 BlameAt(remoteOrLocalFilename, "HEAD")
 func (rc *Repository) GetLineCodeAuthor(remoteOrLocalFilename string) (lineCodeAuthors LineCodeAuthors, err error) {
-	repositoryFileName := RepositoryFilename(remoteOrLocalFilename)
-	r := rc._r
-	lineCodeAuthors = make(LineCodeAuthors, 0)
-	headRef, err := r.Head()
-	if err != nil {
-		return nil, err
-	}
-	headCommit, err := r.CommitObject(headRef.Hash())
-	if err != nil {
-		return nil, err
-	}
-	blameResult, err := git.Blame(headCommit, repositoryFileName)
-	if err != nil {
-		return nil, err
-	}
-	for i, line := range blameResult.Lines {
-		lineAuthor := LineWithAuthor{
-			LinNo:  i,
-			Text:   line.Text,
-			Author: Author(line.Author),
-			Time:   line.Date,
-		}
-		lineCodeAuthors = append(lineCodeAuthors, lineAuthor)
-	}
-	return lineCodeAuthors, nil
+	return rc.BlameAt(remoteOrLocalFilename, plumbing.HEAD.String())
 }
 
+// Exists 文件在 HEAD 中是否存在
 func (rc *Repository) Exists(remoteOrLocalFilename string) (exits bool, err error) {
-	repositoryFileName := RepositoryFilename(remoteOrLocalFilename)
+	repositoryFileName := rc.repositoryFilename(remoteOrLocalFilename)
 	r := rc._r
 	headRef, err := r.Head()
 	if err != nil {
@@ -379,15 +777,16 @@ func (rc *Repository) Exists(remoteOrLocalFilename string) (exits bool, err erro
 		}
 		return false, err
 	}
-	return false, nil
+	return true, nil
 }
 
 // 每行代码附带作者
 type LineWithAuthor struct {
 	LinNo  int
 	Text   string
-	Author Author
+	Author Author // 经 .mailmap 解析的身份
 	Time   time.Time
+	Commit string // 最后修改该行的提交
 }
 
 type LineCodeAuthors []LineWithAuthor
@@ -407,10 +806,9 @@ func (lcas LineCodeAuthors) GetOneLineAuthors(lineNo int) (lwca LineWithAuthor,
 	return lwca, true
 }
 
-// GetAuths 获取某段代码的作者
+// GetAuths 获取某段代码的作者,同一身份(ID 相同)只出现一次,按首次出现的顺序
 func (lcas LineCodeAuthors) GetMutilLineAuthors(star, end int) (authors Authors) {
 	authors = make(Authors, 0)
-	authorMap := make(map[Author]struct{})
 	l := len(lcas)
 	if star >= l {
 		return authors
@@ -423,12 +821,7 @@ func (lcas LineCodeAuthors) GetMutilLineAuthors(star, end int) (authors Authors)
 		if i >= l {
 			break
 		}
-		author := Author(lcas[i].Author)
-		authorMap[author] = struct{}{}
-	}
-
-	for auth := range authorMap {
-		authors = append(authors, auth)
+		authors.AddIngore(lcas[i].Author)
 	}
 	return authors
 }
@@ -450,44 +843,3 @@ func CreateLineCodeAuthorsFromIOReader(reader io.Reader, author Author) (lcas Li
 	}
 	return lcas
 }
-
-type Author string
-type Authors []Author
-
-func (a Authors) Len() int {
-	return len(a)
-}
-func (a Authors) Has(author Author) (ok bool) {
-	for _, author2 := range a {
-		if author == author2 {
-			return true
-		}
-	}
-	return false
-}
-func (a Authors) Only(author Author) (ok bool) {
-	ok = a.Len() == 1 && a.Has(author)
-	return ok
-}
-
-func (a *Authors) AddIngore(authors ...Author) (ok bool) {
-	for _, author := range authors {
-		if a.Has(author) {
-			continue
-		}
-		*a = append(*a, author)
-	}
-	return ok
-}
-
-func (a *Authors) Equal(authors Authors) (ok bool) {
-	if len(*a) != len(authors) {
-		return false
-	}
-	for _, author := range authors {
-		if !a.Has(author) {
-			return false
-		}
-	}
-	return true
-}
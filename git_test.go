@@ -1,7 +1,9 @@
 package gitauto
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -78,14 +80,14 @@ func TestAllowPull(t *testing.T) {
 	count := 6
 	for i := 0; i < count; i++ {
 		w.Add(1)
-		if allowPull(repositoryPath) {
+		if DefaultClient.allowPull(repositoryPath) {
 			actul[allow]++
 			w.Done()
 			continue
 		}
 		go func(i int) {
 			time.Sleep(time.Duration(i) * time.Second)
-			if allowPull(repositoryPath) {
+			if DefaultClient.allowPull(repositoryPath) {
 				actul[allow]++
 				w.Done()
 				return
@@ -173,3 +175,157 @@ func TestGetRepositoryFilenameByLocalFilename(t *testing.T) {
 	repositoryFilename := getRepositoryFilenameByLocalFilename(localFilename)
 	fmt.Println(repositoryFilename)
 }
+
+func TestReadFileAt(t *testing.T) {
+	rc := newLocalRepository(t, map[string]string{"doc/a.md": "v1"})
+	headRef, err := rc._r.Head()
+	require.NoError(t, err)
+	_, err = rc._r.CreateTag("v1.0.0", headRef.Hash(), nil)
+	require.NoError(t, err)
+	err = rc.AddReplaceFileToStage("doc/a.md", []byte("v2"))
+	require.NoError(t, err)
+	_, err = rc.commit("v2", User{Name: "robot", Email: "robot@example.com"}, nil)
+	require.NoError(t, err)
+	err = rc.AddReplaceFileToStage("doc/a.md", []byte("worktree"))
+	require.NoError(t, err)
+
+	cases := map[string]string{
+		"v1.0.0":                    "v1",
+		"HEAD~1":                    "v1",
+		"HEAD":                      "v2",
+		"master":                    "v2",
+		headRef.Hash().String()[:7]: "v1",
+	}
+	for rev, expected := range cases {
+		b, err := rc.ReadFileAt("doc/a.md", rev)
+		require.NoError(t, err, rev)
+		assert.Equal(t, expected, string(b), rev)
+	}
+	_, err = rc.ReadFileAt("doc/a.md", "not-exists")
+	require.Error(t, err)
+}
+
+func TestSplitRemoteAddress(t *testing.T) {
+	cases := []struct {
+		address   string
+		remoteUrl string
+		ref       string
+		filename  string
+	}{
+		{"git@github.com:suifengpiao14/apidml.git/example/doc/adList.md", "git@github.com:suifengpiao14/apidml.git", "", "example/doc/adList.md"},
+		{"git@github.com:suifengpiao14/apidml.git@v1.2.0/example/doc/adList.md", "git@github.com:suifengpiao14/apidml.git", "v1.2.0", "example/doc/adList.md"},
+		{"ssh://git@gitea.programmerfamily.com:2221/go/coupon.git/doc/a.md#ref=feature-x", "ssh://git@gitea.programmerfamily.com:2221/go/coupon.git", "feature-x", "doc/a.md"},
+		{"ssh://git@gitea.programmerfamily.com:2221/go/coupon.git/doc/a.md#ref=feature/x", "ssh://git@gitea.programmerfamily.com:2221/go/coupon.git", "feature/x", "doc/a.md"},
+		{"ssh://git@gitea.programmerfamily.com:2221/go/coupon.git@v1/doc/a.md#ref=v2", "ssh://git@gitea.programmerfamily.com:2221/go/coupon.git", "v2", "doc/a.md"},
+		{"git@github.com:suifengpiao14/apidml.git@2f1c0de", "git@github.com:suifengpiao14/apidml.git", "2f1c0de", ""},
+		{"git@github.com:suifengpiao14/apidml.git", "git@github.com:suifengpiao14/apidml.git", "", ""},
+		{"doc/a.md", "", "", "doc/a.md"},
+	}
+	for _, c := range cases {
+		remoteUrl, ref, filename := splitRemoteAddress(c.address)
+		assert.Equal(t, c.remoteUrl, remoteUrl, c.address)
+		assert.Equal(t, c.ref, ref, c.address)
+		assert.Equal(t, c.filename, filename, c.address)
+	}
+	assert.Equal(t, RobotWorkDir+"/github.com/suifengpiao14/apidml", GetWorkDir("git@github.com:suifengpiao14/apidml.git@v1.2.0/example/doc/adList.md"))
+}
+
+func TestReadFileLocalRemote(t *testing.T) {
+	root := useRobotWorkDir(t)
+	remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a"})
+	t.Run("bare path", func(t *testing.T) {
+		b, err := ReadFile(remotePath + "/doc/a.md")
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b))
+		assert.DirExists(t, fmt.Sprintf("%s/local%s", root, strings.TrimSuffix(remotePath, ".git")))
+	})
+	t.Run("file url", func(t *testing.T) {
+		b, err := ReadFile("file://" + remotePath + "@master/doc/a.md")
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b))
+	})
+	t.Run("hash in filename", func(t *testing.T) {
+		remotePath := newBareRemote(t, map[string]string{"doc/a#b.md": "ab"})
+		b, err := ReadFile(remotePath + "/doc/a#b.md")
+		require.NoError(t, err)
+		assert.Equal(t, "ab", string(b))
+		b, err = ReadFile(remotePath + "/doc/a#b.md#ref=master")
+		require.NoError(t, err)
+		assert.Equal(t, "ab", string(b))
+	})
+	t.Run("branch after fetch", func(t *testing.T) {
+		c := NewClient(WithClientWorkDir(WorkDirConfig{Root: t.TempDir()}), WithAllowPullPeriod(time.Nanosecond))
+		b, err := c.ReadFile(remotePath + "@master/doc/a.md")
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b))
+
+		other := cloneRemote(t, remotePath)
+		err = other.AddReplaceFileToStage("doc/a.md", []byte("a2"))
+		require.NoError(t, err)
+		err = other.CommitWithPush("a2", User{Name: "robot", Email: "robot@example.com"})
+		require.NoError(t, err)
+
+		b, err = c.ReadFile(remotePath + "@master/doc/a.md")
+		require.NoError(t, err)
+		assert.Equal(t, "a2", string(b), "branch resolves to the fetched remote branch, not the stale local one")
+		rc, err := c.NewRepository(remotePath)
+		require.NoError(t, err)
+		b, err = rc.ReadFileAt("doc/a.md", "HEAD")
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b), "HEAD stays local")
+	})
+}
+
+func TestExists(t *testing.T) {
+	rc := newLocalRepository(t, map[string]string{"doc/a.md": "a"})
+	ok, err := rc.Exists("doc/a.md")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = rc.Exists("doc/missing.md")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestContextCancel(t *testing.T) {
+	remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a"})
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	t.Run("clone", func(t *testing.T) {
+		c := NewClient(WithClientWorkDir(WorkDirConfig{Root: t.TempDir()}))
+		_, err := c.NewRepositoryContext(canceled, remotePath)
+		require.ErrorIs(t, err, context.Canceled)
+		_, err = c.ReadFileContext(expired, remotePath+"/doc/a.md")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("pull", func(t *testing.T) {
+		rc := cloneRemote(t, remotePath)
+		err := rc.PullContext(canceled)
+		require.ErrorIs(t, err, context.Canceled)
+		err = rc.FetchContext(expired)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("push", func(t *testing.T) {
+		rc := cloneRemote(t, remotePath)
+		before := remoteBranchHash(t, remotePath, rc.LocalBranch)
+		err := rc.AddReplaceFileToStage("doc/a.md", []byte("a2"))
+		require.NoError(t, err)
+		committed, err := rc.commit("a2", User{Name: "robot", Email: "robot@example.com"}, nil)
+		require.NoError(t, err)
+		require.True(t, committed)
+		err = rc.push(canceled)
+		require.ErrorIs(t, err, context.Canceled)
+		err = rc.push(expired)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, before, remoteBranchHash(t, remotePath, rc.LocalBranch), "remote branch unchanged")
+
+		err = rc.AddReplaceFileToStage("doc/a.md", []byte("a3"))
+		require.NoError(t, err)
+		err = rc.CommitWithPushContext(expired, "a3", User{Name: "robot", Email: "robot@example.com"})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,283 @@
+package gitauto
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+)
+
+// MailmapFilename 仓库根目录下的 .mailmap,把同一个人的多个名称、邮箱映射为同一身份
+const MailmapFilename = ".mailmap"
+
+// Author 作者身份,ID 为规范标识(经 .mailmap 解析后的小写邮箱,没有邮箱时为名称),比较作者时只比较 ID
+type Author struct {
+	Name  string
+	Email string
+	ID    string
+	Robot bool // 是否为程序身份,见 WithRobots
+}
+
+// NewAuthor 新建作者身份,未经 .mailmap 解析,需要时用 Repository.Identity
+func NewAuthor(name string, email string) Author {
+	return Author{Name: name, Email: email, ID: authorID(name, email)}
+}
+
+// authorID 规范标识:小写邮箱,没有邮箱时为名称
+func authorID(name string, email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email != "" {
+		return email
+	}
+	return strings.TrimSpace(name)
+}
+
+// key 比较用的标识,ID 为空(直接构造的 Author)时按名称、邮箱计算
+func (a Author) key() string {
+	if a.ID != "" {
+		return a.ID
+	}
+	return authorID(a.Name, a.Email)
+}
+
+// Equal 是否为同一身份
+func (a Author) Equal(other Author) bool {
+	return a.key() == other.key()
+}
+
+// Addr 邮箱,没有邮箱时为名称;Author 为字符串时其值即 blame 中的邮箱,string(author) 改为 author.Addr()
+func (a Author) Addr() string {
+	if a.Email == "" {
+		return a.Name
+	}
+	return a.Email
+}
+
+func (a Author) String() string {
+	if a.Email == "" {
+		return a.Name
+	}
+	if a.Name == "" {
+		return fmt.Sprintf("<%s>", a.Email)
+	}
+	return fmt.Sprintf("%s <%s>", a.Name, a.Email)
+}
+
+type Authors []Author
+
+func (a Authors) Len() int {
+	return len(a)
+}
+func (a Authors) Has(author Author) (ok bool) {
+	for _, author2 := range a {
+		if author.Equal(author2) {
+			return true
+		}
+	}
+	return false
+}
+func (a Authors) Only(author Author) (ok bool) {
+	ok = a.Len() == 1 && a.Has(author)
+	return ok
+}
+
+// Emails 作者邮箱(没有邮箱时为名称),与 Author 为字符串时 []string(authors) 的值一致,供按字符串处理作者的调用方过渡
+func (a Authors) Emails() (emails []string) {
+	emails = make([]string, 0, len(a))
+	for _, author := range a {
+		emails = append(emails, author.Addr())
+	}
+	return emails
+}
+
+// Humans 非程序身份的作者
+func (a Authors) Humans() (humans Authors) {
+	humans = make(Authors, 0)
+	for _, author := range a {
+		if !author.Robot {
+			humans = append(humans, author)
+		}
+	}
+	return humans
+}
+
+func (a *Authors) AddIngore(authors ...Author) (ok bool) {
+	for _, author := range authors {
+		if a.Has(author) {
+			continue
+		}
+		*a = append(*a, author)
+	}
+	return ok
+}
+
+func (a *Authors) Equal(authors Authors) (ok bool) {
+	if len(*a) != len(authors) {
+		return false
+	}
+	for _, author := range authors {
+		if !a.Has(author) {
+			return false
+		}
+	}
+	return true
+}
+
+// WithRobots 设置程序身份(如提交时使用的 User),经 .mailmap 解析后 ID 相同的作者 Robot 为 true
+func WithRobots(robots ...Author) ClientOption {
+	return func(c *Client) {
+		c.robots = append(c.robots, robots...)
+	}
+}
+
+// mailmapEntry .mailmap 中的一条映射,commitName 为空时只按邮箱匹配
+type mailmapEntry struct {
+	properName  string
+	properEmail string
+	commitName  string
+	commitEmail string
+}
+
+// Mailmap 解析后的 .mailmap
+type Mailmap struct {
+	entries []mailmapEntry
+}
+
+// ParseMailmap 解析 .mailmap,支持 git 的四种格式:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func ParseMailmap(content string) (m Mailmap) {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i > -1 {
+			line = line[:i]
+		}
+		names, emails := make([]string, 0, 2), make([]string, 0, 2)
+		for {
+			begin := strings.Index(line, "<")
+			end := strings.Index(line, ">")
+			if begin < 0 || end < begin {
+				break
+			}
+			names = append(names, strings.TrimSpace(line[:begin]))
+			emails = append(emails, strings.TrimSpace(line[begin+1:end]))
+			line = line[end+1:]
+		}
+		switch len(emails) {
+		case 1:
+			m.entries = append(m.entries, mailmapEntry{properName: names[0], commitEmail: emails[0]})
+		case 2:
+			m.entries = append(m.entries, mailmapEntry{properName: names[0], properEmail: emails[0], commitName: names[1], commitEmail: emails[1]})
+		}
+	}
+	return m
+}
+
+// Resolve 按 .mailmap 解析名称和邮箱,名称和邮箱都匹配的映射优先于只匹配邮箱的映射
+func (m Mailmap) Resolve(name string, email string) (properName string, properEmail string) {
+	properName, properEmail = name, email
+	var matched *mailmapEntry
+	for i, entry := range m.entries {
+		if !strings.EqualFold(entry.commitEmail, email) {
+			continue
+		}
+		if entry.commitName != "" {
+			if entry.commitName != name {
+				continue
+			}
+			matched = &m.entries[i]
+			break
+		}
+		if matched == nil {
+			matched = &m.entries[i]
+		}
+	}
+	if matched == nil {
+		return properName, properEmail
+	}
+	if matched.properName != "" {
+		properName = matched.properName
+	}
+	if matched.properEmail != "" {
+		properEmail = matched.properEmail
+	}
+	return properName, properEmail
+}
+
+// identities 按 .mailmap、程序身份解析作者
+type identities struct {
+	mailmap Mailmap
+	robots  map[string]struct{}
+}
+
+func (ids identities) resolve(name string, email string) (author Author) {
+	name, email = ids.mailmap.Resolve(name, email)
+	author = NewAuthor(name, email)
+	_, author.Robot = ids.robots[author.ID]
+	return author
+}
+
+// identities 读取提交中的 .mailmap,并按其解析程序身份;commit 为 nil(空仓库)或没有 .mailmap 时不做映射
+func (rc *Repository) identities(commit *object.Commit) (ids identities, err error) {
+	content := ""
+	if commit != nil {
+		f, err := commit.File(MailmapFilename)
+		if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+			return ids, err
+		}
+		if err == nil {
+			content, err = f.Contents()
+			if err != nil {
+				return ids, err
+			}
+		}
+	}
+	ids.mailmap = ParseMailmap(content)
+	ids.robots = make(map[string]struct{})
+	for _, robot := range rc._client.robots {
+		name, email := ids.mailmap.Resolve(robot.Name, robot.Email)
+		ids.robots[authorID(name, email)] = struct{}{}
+	}
+	return ids, nil
+}
+
+// Identity 按 HEAD 中的 .mailmap 和 WithRobots 解析作者身份
+func (rc *Repository) Identity(name string, email string) (author Author, err error) {
+	var head *object.Commit
+	ref, err := rc._r.Head()
+	if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return Author{}, err
+	}
+	if err == nil {
+		head, err = rc._r.CommitObject(ref.Hash())
+		if err != nil {
+			return Author{}, err
+		}
+	}
+	ids, err := rc.identities(head)
+	if err != nil {
+		return Author{}, err
+	}
+	return ids.resolve(name, email), nil
+}
+
+// resolveAuthors 按 commit 中的 .mailmap 把 blame 结果中的原始名称、邮箱解析为身份
+func (rc *Repository) resolveAuthors(commit *object.Commit, lineCodeAuthors LineCodeAuthors) (resolved LineCodeAuthors, err error) {
+	ids, err := rc.identities(commit)
+	if err != nil {
+		return nil, err
+	}
+	resolved = make(LineCodeAuthors, len(lineCodeAuthors))
+	for i, line := range lineCodeAuthors {
+		line.Author = ids.resolve(line.Author.Name, line.Author.Email)
+		resolved[i] = line
+	}
+	return resolved, nil
+}
@@ -0,0 +1,94 @@
+package gitauto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailmap(t *testing.T) {
+	m := ParseMailmap(`# comment
+Alice Smith <alice@example.com>
+<alice@example.com> <alice@old.example.com>
+Bot <bot@example.com> <ci@example.com>
+Alice Smith <alice@example.com> laptop <root@localhost> # only this name
+`)
+	cases := []struct {
+		name, email string
+		properName  string
+		properEmail string
+	}{
+		{"alice", "alice@example.com", "Alice Smith", "alice@example.com"},
+		{"alice", "ALICE@old.example.com", "alice", "alice@example.com"},
+		{"ci", "ci@example.com", "Bot", "bot@example.com"},
+		{"laptop", "root@localhost", "Alice Smith", "alice@example.com"},
+		{"other", "root@localhost", "other", "root@localhost"},
+		{"bob", "bob@example.com", "bob", "bob@example.com"},
+	}
+	for _, c := range cases {
+		name, email := m.Resolve(c.name, c.email)
+		assert.Equal(t, c.properName, name, c.email)
+		assert.Equal(t, c.properEmail, email, c.email)
+	}
+}
+
+func TestAuthors(t *testing.T) {
+	alice := NewAuthor("Alice", "Alice@Example.com")
+	assert.Equal(t, "alice@example.com", alice.ID)
+	assert.True(t, alice.Equal(Author{Name: "alice smith", Email: "alice@example.com"}))
+	authors := Authors{}
+	authors.AddIngore(alice, NewAuthor("A. Smith", "alice@example.com"))
+	assert.True(t, authors.Only(NewAuthor("", "alice@example.com")))
+	assert.True(t, authors.Equal(Authors{NewAuthor("x", "ALICE@example.com")}))
+	assert.False(t, authors.Has(NewAuthor("Alice", "")))
+	assert.Equal(t, "Alice <Alice@Example.com>", alice.String())
+}
+
+func TestIdentity(t *testing.T) {
+	rc := newLocalRepository(t, map[string]string{
+		"doc/a.md": "title\n",
+		".mailmap": "Alice <alice@example.com> <alice@home.example.com>\nRobot <robot@example.com> <ci@example.com>\n",
+	})
+	rc._client = NewClient(WithRobots(NewAuthor("robot", "robot@example.com")))
+	commitAs(t, rc, "alice@example.com", map[string]string{"doc/a.md": "title\nline1\n"})
+	commitAs(t, rc, "alice@home.example.com", map[string]string{"doc/a.md": "title\nline1\nline2\n"})
+	commitAs(t, rc, "ci@example.com", map[string]string{"doc/a.md": "title\nline1\nline2\ngenerated\n"})
+
+	lines, err := rc.GetLineCodeAuthor("doc/a.md")
+	require.NoError(t, err)
+	require.Len(t, lines, 4)
+	assert.Equal(t, Author{Name: "Alice", Email: "alice@example.com", ID: "alice@example.com"}, lines[2].Author)
+	assert.True(t, lines[3].Author.Robot)
+	assert.Equal(t, "Robot", lines[3].Author.Name)
+
+	alice := NewAuthor("Alice", "alice@example.com")
+	assert.True(t, lines.GetMutilLineAuthors(2, 3).Only(alice), "both emails resolve to one identity")
+	authors := lines.GetMutilLineAuthors(2, 4)
+	assert.Len(t, authors, 2)
+	assert.Equal(t, Authors{lines[1].Author}, authors.Humans())
+
+	robot, err := rc.Identity("ci", "ci@example.com")
+	require.NoError(t, err)
+	assert.True(t, robot.Robot)
+	assert.Equal(t, "robot@example.com", robot.ID)
+
+	impact, err := rc.ImpactOf("doc/a.md", []byte("title\n"))
+	require.NoError(t, err)
+	humans := impact.Humans()
+	require.Len(t, humans, 1)
+	assert.Equal(t, 2, humans[0].Total())
+	assert.Equal(t, []string{"alice@example.com", "robot@example.com"}, authors.Emails())
+
+	// 按所 blame 版本中的 .mailmap 解析,之后的修改和工作区的内容不影响旧版本
+	old := commitAs(t, rc, "alice@example.com", map[string]string{".mailmap": "Alice <alice@example.com>\n"})
+	commitAs(t, rc, "alice@example.com", map[string]string{".mailmap": "Carol <carol@example.com> <alice@home.example.com>\n"})
+	err = rc.AddReplaceFileToStage(".mailmap", []byte("Dave <dave@example.com> <alice@home.example.com>\n"))
+	require.NoError(t, err)
+	lines, err = rc.BlameAt("doc/a.md", old.String())
+	require.NoError(t, err)
+	assert.Equal(t, NewAuthor("alice@home.example.com", "alice@home.example.com"), lines[2].Author)
+	lines, err = rc.GetLineCodeAuthor("doc/a.md")
+	require.NoError(t, err)
+	assert.Equal(t, NewAuthor("Carol", "carol@example.com"), lines[2].Author)
+}
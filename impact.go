@@ -0,0 +1,123 @@
+package gitauto
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+)
+
+// AuthorImpact 写入新内容会删除、修改某作者的行数及这些行的最后修改时间范围
+type AuthorImpact struct {
+	Author   Author
+	Removed  int       // 被删除的行数
+	Modified int       // 被替换为其它内容的行数
+	Lines    []int     // 受影响的行在 HEAD 中的行号,从1开始
+	Oldest   time.Time // 受影响的行中最早的修改时间
+	Newest   time.Time // 受影响的行中最近的修改时间
+}
+
+// Total 受影响的总行数
+func (a AuthorImpact) Total() int {
+	return a.Removed + a.Modified
+}
+
+// Impact 覆盖写入文件对 HEAD 中已有行的影响,新增的行不计入
+type Impact struct {
+	Filename string
+	Commit   string // 比较的 HEAD 提交
+	Removed  int
+	Modified int
+	Authors  []AuthorImpact // 按 Newest 从近到远排序
+}
+
+// Except 排除指定作者(如程序自身)后受影响的作者,用于判断是否会覆盖人工修改
+func (im Impact) Except(authors ...Author) (impacts []AuthorImpact) {
+	impacts = make([]AuthorImpact, 0)
+	for _, impact := range im.Authors {
+		if Authors(authors).Has(impact.Author) {
+			continue
+		}
+		impacts = append(impacts, impact)
+	}
+	return impacts
+}
+
+// Humans 排除程序身份(见 WithRobots)后受影响的作者
+func (im Impact) Humans() (impacts []AuthorImpact) {
+	impacts = make([]AuthorImpact, 0)
+	for _, impact := range im.Authors {
+		if !impact.Author.Robot {
+			impacts = append(impacts, impact)
+		}
+	}
+	return impacts
+}
+
+// ImpactOf 比较新内容与 HEAD 中的文件,按作者(经 .mailmap 解析的身份)统计会被删除、修改的行;文件在 HEAD 中不存在时没有影响。
+// 新内容与 AddReplaceFileToStage 一样先带入保护区域内的内容,标记不匹配时返回 *KeepMarkerError
+func (rc *Repository) ImpactOf(remoteOrLocalFilename string, newContent []byte) (impact *Impact, err error) {
+	filename := rc.repositoryFilename(remoteOrLocalFilename)
+	newContent, err = rc.keepRegions(filename, newContent)
+	if err != nil {
+		return nil, err
+	}
+	impact = &Impact{Filename: filename, Authors: make([]AuthorImpact, 0)}
+	head, err := rc.resolveCommit(plumbing.HEAD.String())
+	if err != nil {
+		return nil, err
+	}
+	impact.Commit = head.Hash.String()
+	lineCodeAuthors, err := rc.blame(head, filename)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return impact, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lineCodeAuthors, err = rc.resolveAuthors(head, lineCodeAuthors)
+	if err != nil {
+		return nil, err
+	}
+	headLines := make([]string, 0, len(lineCodeAuthors))
+	for _, line := range lineCodeAuthors {
+		headLines = append(headLines, line.Text)
+	}
+	byAuthor := make(map[string]*AuthorImpact) // 身份 ID => 影响
+	for _, hunk := range diffHunks(headLines, splitLines(string(newContent))) {
+		for i := hunk.start; i < hunk.end; i++ {
+			line := lineCodeAuthors[i]
+			authorImpact, ok := byAuthor[line.Author.ID]
+			if !ok {
+				authorImpact = &AuthorImpact{Author: line.Author, Oldest: line.Time, Newest: line.Time}
+				byAuthor[line.Author.ID] = authorImpact
+			}
+			if i-hunk.start < len(hunk.lines) { // 替换的行计为修改,超出新内容行数的计为删除
+				authorImpact.Modified++
+				impact.Modified++
+			} else {
+				authorImpact.Removed++
+				impact.Removed++
+			}
+			authorImpact.Lines = append(authorImpact.Lines, i+1)
+			if line.Time.Before(authorImpact.Oldest) {
+				authorImpact.Oldest = line.Time
+			}
+			if line.Time.After(authorImpact.Newest) {
+				authorImpact.Newest = line.Time
+			}
+		}
+	}
+	for _, authorImpact := range byAuthor {
+		impact.Authors = append(impact.Authors, *authorImpact)
+	}
+	sort.Slice(impact.Authors, func(i, j int) bool {
+		if !impact.Authors[i].Newest.Equal(impact.Authors[j].Newest) {
+			return impact.Authors[i].Newest.After(impact.Authors[j].Newest)
+		}
+		return impact.Authors[i].Author.ID < impact.Authors[j].Author.ID
+	})
+	return impact, nil
+}
@@ -0,0 +1,49 @@
+package gitauto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImpactOf(t *testing.T) {
+	rc := newLocalRepository(t, map[string]string{"doc/a.md": "title\n"})
+	robot := NewAuthor("robot", "robot@example.com")
+	commitAs(t, rc, robot.Email, map[string]string{"doc/a.md": "title\nfield1\nfield2\nfield3\n"})
+	commitAs(t, rc, "alice@example.com", map[string]string{"doc/a.md": "title\nfield1 // alice\nfield2\nfield3\nalice note\n"})
+
+	impact, err := rc.ImpactOf("doc/a.md", []byte("title\nfield1\nfield2\nfield4\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, impact.Modified)
+	assert.Equal(t, 1, impact.Removed)
+	require.Len(t, impact.Authors, 2)
+	alice := impact.Authors[0]
+	assert.Equal(t, "alice@example.com", alice.Author.ID)
+	assert.Equal(t, 1, alice.Modified)
+	assert.Equal(t, 1, alice.Removed)
+	assert.Equal(t, []int{2, 5}, alice.Lines)
+	assert.False(t, alice.Newest.Before(alice.Oldest))
+	assert.Equal(t, AuthorImpact{Author: NewAuthor("robot@example.com", "robot@example.com"), Modified: 1, Lines: []int{4}, Oldest: impact.Authors[1].Oldest, Newest: impact.Authors[1].Newest}, impact.Authors[1])
+
+	humans := impact.Except(robot)
+	require.Len(t, humans, 1)
+	assert.Equal(t, 2, humans[0].Total())
+
+	impact, err = rc.ImpactOf("doc/new.md", []byte("new\n"))
+	require.NoError(t, err)
+	assert.Empty(t, impact.Authors)
+
+	t.Run("keep regions", func(t *testing.T) {
+		commitAs(t, rc, "bob@example.com", map[string]string{"doc/k.md": "generated\n<!-- gitauto:keep begin -->\nbob note\n<!-- gitauto:keep end -->\n"})
+		impact, err := rc.ImpactOf("doc/k.md", []byte("regenerated\n<!-- gitauto:keep begin -->\n<!-- gitauto:keep end -->\n"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, impact.Modified)
+		assert.Equal(t, 0, impact.Removed, "lines inside keep region are preserved")
+
+		_, err = rc.ImpactOf("doc/k.md", []byte("regenerated\n"))
+		var markerErr *KeepMarkerError
+		require.ErrorAs(t, err, &markerErr)
+		assert.ErrorIs(t, err, ErrKeepMarker)
+	})
+}
@@ -0,0 +1,298 @@
+package gitauto
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/pkg/errors"
+)
+
+// MetaDirName 本库在 .git 目录下保存元数据(最近访问时间、blame 缓存等)的目录
+const MetaDirName = "gitauto"
+
+// accessMarkerName 最近访问时间标记文件,修改时间即最近访问时间
+const accessMarkerName = "access"
+
+// PruneGracePeriod 回收时只删除早于该时长、且不被任何引用可达的松散对象,与 git gc 默认值一致
+var PruneGracePeriod = 14 * 24 * time.Hour
+
+// markAccess 记录工作目录最近访问时间
+func markAccess(workDir string, now time.Time) (err error) {
+	marker := filepath.Join(workDir, git.GitDirName, MetaDirName, accessMarkerName)
+	err = os.MkdirAll(filepath.Dir(marker), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(marker, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(marker, now, now)
+}
+
+// lastAccess 工作目录最近访问时间,没有访问记录时使用 .git 目录修改时间
+func lastAccess(workDir string) (t time.Time, err error) {
+	s, err := os.Stat(filepath.Join(workDir, git.GitDirName, MetaDirName, accessMarkerName))
+	if os.IsNotExist(err) {
+		s, err = os.Stat(filepath.Join(workDir, git.GitDirName))
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.ModTime(), nil
+}
+
+// JanitorOptions 工作目录回收策略,MaxTotalSize、MaxAge 都为0时只做 Repack
+type JanitorOptions struct {
+	MaxTotalSize int64         // 工作根目录下所有仓库总大小上限(字节),超过时按最近访问时间从早到晚删除,0 表示不限制
+	MaxAge       time.Duration // 超过该时长未访问的仓库直接删除,0 表示不限制
+	Repack       bool          // 保留的仓库清理不可达的松散对象并重新打包
+}
+
+// JanitorResult 一次回收的结果,均为工作目录
+type JanitorResult struct {
+	Evicted   []string
+	Skipped   []string // 被锁定或有未提交变更,未处理
+	Repacked  []string
+	TotalSize int64 // 回收后剩余总大小(字节)
+}
+
+// workDirUsage 工作目录占用情况
+type workDirUsage struct {
+	root       string // 所在工作根目录
+	workDir    string
+	size       int64
+	lastAccess time.Time
+}
+
+// CollectGarbage 回收工作根目录下的仓库:先删除超过 MaxAge 未访问的,再按最近访问时间从早到晚删除直到总大小不超过 MaxTotalSize;
+// 正被其它调用方或进程锁定、工作区有未提交变更的仓库跳过。被删除仓库此前获取的 Repository 不能再使用,需重新 NewRepository
+func (c *Client) CollectGarbage(ctx context.Context, opts JanitorOptions) (result *JanitorResult, err error) {
+	result = &JanitorResult{}
+	usages, err := c.workDirUsages()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].lastAccess.Before(usages[j].lastAccess)
+	})
+	for _, usage := range usages {
+		result.TotalSize += usage.size
+	}
+	now := c.now()
+	for _, usage := range usages {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		expired := opts.MaxAge > 0 && now.Sub(usage.lastAccess) > opts.MaxAge
+		overQuota := opts.MaxTotalSize > 0 && result.TotalSize > opts.MaxTotalSize
+		evict := expired || overQuota
+		if !evict && !opts.Repack {
+			continue
+		}
+		handled, err := c.withIdleWorkDir(usage.root, usage.workDir, func(entry *repositoryEntry, r *git.Repository) error {
+			if evict {
+				return c.evict(entry, usage.workDir)
+			}
+			return repack(r, now)
+		})
+		if err != nil {
+			return result, errors.WithMessage(err, usage.workDir)
+		}
+		switch {
+		case !handled:
+			c.logf("gitauto: janitor skip %s: locked or dirty", usage.workDir)
+			result.Skipped = append(result.Skipped, usage.workDir)
+		case evict:
+			c.logf("gitauto: janitor evict %s (%d bytes, last access %s)", usage.workDir, usage.size, usage.lastAccess.Format(time.RFC3339))
+			result.Evicted = append(result.Evicted, usage.workDir)
+			result.TotalSize -= usage.size
+		default:
+			result.Repacked = append(result.Repacked, usage.workDir)
+		}
+	}
+	return result, nil
+}
+
+// RunJanitor 每隔 interval 执行一次 CollectGarbage,直到ctx结束,错误只记录日志
+func (c *Client) RunJanitor(ctx context.Context, interval time.Duration, opts JanitorOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := c.CollectGarbage(ctx, opts)
+		if err != nil && ctx.Err() == nil {
+			c.logf("gitauto: janitor: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// workDirUsages 查找工作根目录(Client 的根目录及已打开仓库通过 WithWorkDirConfig 指定的根目录)下所有仓库工作目录及其大小、最近访问时间
+func (c *Client) workDirUsages() (usages []workDirUsage, err error) {
+	seen := make(map[string]bool) // 根目录嵌套时同一工作目录只统计一次
+	for _, root := range c.workDirRoots() {
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == root {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if path != root && (d.Name() == LockDirName || d.Name() == QuarantineDirName) {
+				return filepath.SkipDir
+			}
+			if !IsDir(filepath.Join(path, git.GitDirName)) {
+				return nil
+			}
+			workDir := filepath.ToSlash(path)
+			if seen[workDir] {
+				return filepath.SkipDir
+			}
+			seen[workDir] = true
+			usage := workDirUsage{root: root, workDir: workDir}
+			usage.size, err = dirSize(path)
+			if err != nil {
+				return err
+			}
+			usage.lastAccess, err = lastAccess(path)
+			if err != nil {
+				return err
+			}
+			usages = append(usages, usage)
+			return filepath.SkipDir
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return usages, nil
+}
+
+// workDirRoots Client 的工作根目录及已打开仓库的工作根目录,去重后按字典序排列(Client 的根目录在前)
+func (c *Client) workDirRoots() (roots []string) {
+	root := c.workDir.RootDir()
+	others := make(map[string]bool)
+	c.repositories.Range(func(key, value interface{}) bool {
+		entry := value.(*repositoryEntry)
+		if !entry.mu.TryLock() { // 正在打开,下次回收时再统计
+			return true
+		}
+		if entry.rc != nil && entry.rc._root != "" && entry.rc._root != root {
+			others[entry.rc._root] = true
+		}
+		entry.mu.Unlock()
+		return true
+	})
+	roots = append(roots, root)
+	sorted := make([]string, 0, len(others))
+	for other := range others {
+		sorted = append(sorted, other)
+	}
+	sort.Strings(sorted)
+	return append(roots, sorted...)
+}
+
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// withIdleWorkDir 工作目录未被锁定且工作区干净时,持有进程内和跨进程锁执行fn,否则返回 handled=false
+func (c *Client) withIdleWorkDir(root string, workDir string, fn func(entry *repositoryEntry, r *git.Repository) error) (handled bool, err error) {
+	entry := c.getRepositoryEntry(workDir)
+	entry.mu.Lock() // 阻止同时打开该仓库
+	defer entry.mu.Unlock()
+	if rc := entry.rc; rc != nil {
+		select {
+		case rc._sem <- struct{}{}:
+			defer func() { <-rc._sem }()
+		default:
+			return false, nil
+		}
+	}
+	fl := newFileLock(root, workDir)
+	ok, err := fl.tryLock()
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+	defer fl.Unlock()
+	r, err := git.PlainOpen(workDir)
+	if err != nil {
+		return false, err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return false, err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return false, err
+	}
+	if !status.IsClean() {
+		return false, nil
+	}
+	err = fn(entry, r)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// evict 删除工作目录并清空注册表条目,下次 NewRepository 时重新clone,调用方需持有条目锁
+func (c *Client) evict(entry *repositoryEntry, workDir string) (err error) {
+	err = os.RemoveAll(workDir)
+	if err != nil {
+		return err
+	}
+	entry.rc = nil
+	c.pullLimiter.Delete(workDir)
+	return nil
+}
+
+// repack 删除不可达的松散对象并把所有对象重新打包,相当于 git gc
+func repack(r *git.Repository, now time.Time) (err error) {
+	err = r.Prune(git.PruneOptions{
+		OnlyObjectsOlderThan: now.Add(-PruneGracePeriod),
+		Handler:              r.DeleteObject,
+	})
+	if err != nil {
+		return err
+	}
+	err = r.RepackObjects(&git.RepackConfig{})
+	if err != nil {
+		return err
+	}
+	return nil
+}
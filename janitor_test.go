@@ -0,0 +1,101 @@
+package gitauto
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	now := time.Now()
+	c := NewClient(WithClientWorkDir(WorkDirConfig{Root: t.TempDir()}))
+	remotes := map[*Repository]string{}
+	open := func(age time.Duration) (rc *Repository) {
+		remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a"})
+		rc, err := c.NewRepository(remotePath)
+		remotes[rc] = remotePath
+		require.NoError(t, err)
+		err = markAccess(rc._workDir, now.Add(-age))
+		require.NoError(t, err)
+		return rc
+	}
+	old := open(48 * time.Hour)
+	dirty := open(36 * time.Hour)
+	locked := open(24 * time.Hour)
+	recent := open(time.Hour)
+	err := os.WriteFile(filepath.Join(dirty._workDir, "doc/a.md"), []byte("changed"), 0644)
+	require.NoError(t, err)
+	err = locked.Lock(context.Background())
+	require.NoError(t, err)
+	defer locked.Unlock()
+
+	result, err := c.CollectGarbage(context.Background(), JanitorOptions{MaxAge: 12 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, []string{old._workDir}, result.Evicted)
+	assert.ElementsMatch(t, []string{dirty._workDir, locked._workDir}, result.Skipped)
+	assert.False(t, IsDir(old._workDir))
+
+	t.Run("reopen evicted", func(t *testing.T) {
+		rc, err := c.NewRepository(remotes[old])
+		require.NoError(t, err)
+		assert.NotSame(t, old, rc)
+		b, err := rc.ReadFile("doc/a.md")
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b))
+		err = markAccess(rc._workDir, now.Add(-48*time.Hour))
+		require.NoError(t, err)
+		old = rc
+	})
+
+	t.Run("quota", func(t *testing.T) {
+		result, err := c.CollectGarbage(context.Background(), JanitorOptions{MaxTotalSize: 1, Repack: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{old._workDir, recent._workDir}, result.Evicted, "least recently used first, locked and dirty skipped")
+		assert.Empty(t, result.Repacked)
+	})
+
+	t.Run("repack", func(t *testing.T) {
+		err := os.WriteFile(filepath.Join(dirty._workDir, "doc/a.md"), []byte("a"), 0644)
+		require.NoError(t, err)
+		result, err := c.CollectGarbage(context.Background(), JanitorOptions{Repack: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{dirty._workDir}, result.Repacked)
+		r, err := git.PlainOpen(dirty._workDir)
+		require.NoError(t, err)
+		_, err = r.Head()
+		require.NoError(t, err)
+	})
+
+	t.Run("client clock", func(t *testing.T) {
+		c := NewClient(WithClientWorkDir(WorkDirConfig{Root: t.TempDir()}), WithClock(fixedClock(now.Add(-72*time.Hour))))
+		rc, err := c.NewRepository(newBareRemote(t, map[string]string{"doc/a.md": "a"}))
+		require.NoError(t, err)
+		result, err := c.CollectGarbage(context.Background(), JanitorOptions{MaxAge: 12 * time.Hour})
+		require.NoError(t, err)
+		assert.Empty(t, result.Evicted, "age is measured with the client clock")
+		assert.DirExists(t, rc._workDir)
+	})
+
+	t.Run("dot dirs and repository roots", func(t *testing.T) {
+		remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a"})
+		hidden := filepath.ToSlash(filepath.Join(c.workDir.RootDir(), ".cache", "repo"))
+		_, err := git.PlainClone(hidden, false, &git.CloneOptions{URL: remotePath})
+		require.NoError(t, err)
+		require.NoError(t, markAccess(hidden, now.Add(-48*time.Hour)))
+		custom, err := c.NewRepository(remotePath, WithWorkDirConfig(WorkDirConfig{Root: t.TempDir()}))
+		require.NoError(t, err)
+		require.NoError(t, markAccess(custom._workDir, now.Add(-48*time.Hour)))
+
+		result, err := c.CollectGarbage(context.Background(), JanitorOptions{MaxAge: 12 * time.Hour})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{dirty._workDir, hidden, custom._workDir}, result.Evicted)
+		assert.False(t, IsDir(custom._workDir))
+		assert.DirExists(t, filepath.Join(c.workDir.RootDir(), LockDirName), "metadata dirs are kept")
+	})
+}
@@ -0,0 +1,230 @@
+package gitauto
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// 保护区域标记,写在注释中,如 "// gitauto:keep begin"、"# gitauto:keep end"、"<!-- gitauto:keep begin header -->";
+// begin 后可带区域名称,有名称的区域按名称对应,没有名称的按出现顺序对应
+const (
+	KeepBeginMarker = "gitauto:keep begin"
+	KeepEndMarker   = "gitauto:keep end"
+)
+
+// CommentSyntax 单行注释语法,Suffix 用于 <!-- --> 、/* */ 等需要闭合的注释
+type CommentSyntax struct {
+	Prefix string
+	Suffix string
+}
+
+// DefaultCommentSyntaxes 按文件扩展名(小写,含".")的注释语法,可通过 WithCommentSyntax 覆盖或补充;
+// 未配置的扩展名不识别保护区域
+var DefaultCommentSyntaxes = map[string]CommentSyntax{
+	".go":    {Prefix: "//"},
+	".js":    {Prefix: "//"},
+	".ts":    {Prefix: "//"},
+	".java":  {Prefix: "//"},
+	".c":     {Prefix: "//"},
+	".h":     {Prefix: "//"},
+	".cpp":   {Prefix: "//"},
+	".rs":    {Prefix: "//"},
+	".php":   {Prefix: "//"},
+	".proto": {Prefix: "//"},
+	".py":    {Prefix: "#"},
+	".sh":    {Prefix: "#"},
+	".rb":    {Prefix: "#"},
+	".yaml":  {Prefix: "#"},
+	".yml":   {Prefix: "#"},
+	".toml":  {Prefix: "#"},
+	".sql":   {Prefix: "--"},
+	".lua":   {Prefix: "--"},
+	".md":    {Prefix: "<!--", Suffix: "-->"},
+	".html":  {Prefix: "<!--", Suffix: "-->"},
+	".xml":   {Prefix: "<!--", Suffix: "-->"},
+	".vue":   {Prefix: "<!--", Suffix: "-->"},
+	".css":   {Prefix: "/*", Suffix: "*/"},
+}
+
+// WithCommentSyntax 设置扩展名(如 ".tpl")的注释语法,Prefix 为空时该扩展名不识别保护区域
+func WithCommentSyntax(ext string, syntax CommentSyntax) ClientOption {
+	return func(c *Client) {
+		if c.commentSyntaxes == nil {
+			c.commentSyntaxes = make(map[string]CommentSyntax)
+		}
+		c.commentSyntaxes[strings.ToLower(ext)] = syntax
+	}
+}
+
+// commentSyntax 文件的注释语法,未配置时 ok 为 false
+func (c *Client) commentSyntax(filename string) (syntax CommentSyntax, ok bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	syntax, ok = c.commentSyntaxes[ext]
+	if !ok {
+		syntax, ok = DefaultCommentSyntaxes[ext]
+	}
+	return syntax, ok && syntax.Prefix != ""
+}
+
+// ErrKeepMarker 保护区域标记不匹配,可用 errors.Is 判断,详情见 *KeepMarkerError
+var ErrKeepMarker = errors.New("keep marker mismatch")
+
+// KeepMarkerError 保护区域标记不匹配:缺少 begin/end、嵌套、重名,或当前文件的保护区域在新内容中不存在
+type KeepMarkerError struct {
+	Filename string
+	Source   string // current 当前文件,new 新内容
+	Line     int    // 出错的标记所在行号,从1开始
+	Reason   string
+}
+
+func (e *KeepMarkerError) Error() string {
+	return fmt.Sprintf("%s (%s) line %d: %s", e.Filename, e.Source, e.Line, e.Reason)
+}
+
+func (e *KeepMarkerError) Is(target error) bool {
+	return target == ErrKeepMarker
+}
+
+// keepRegion 保护区域,begin、end 为标记所在行下标
+type keepRegion struct {
+	name  string
+	begin int
+	end   int
+}
+
+// marker 解析标记行,kind 为 KeepBeginMarker 或 KeepEndMarker
+func (s CommentSyntax) marker(line string) (kind string, name string, ok bool) {
+	text := strings.TrimSpace(line)
+	if !strings.HasPrefix(text, s.Prefix) {
+		return "", "", false
+	}
+	text = strings.TrimPrefix(text, s.Prefix)
+	if s.Suffix != "" {
+		text = strings.TrimSuffix(text, s.Suffix)
+	}
+	text = strings.TrimSpace(text)
+	for _, kind := range []string{KeepBeginMarker, KeepEndMarker} {
+		if text == kind || strings.HasPrefix(text, kind+" ") {
+			return kind, strings.TrimSpace(strings.TrimPrefix(text, kind)), true
+		}
+	}
+	return "", "", false
+}
+
+// keepRegions 解析所有保护区域,标记不成对、嵌套或重名时返回 *KeepMarkerError
+func (s CommentSyntax) keepRegions(lines []string, newError func(line int, reason string) error) (regions []keepRegion, err error) {
+	var open *keepRegion
+	names := make(map[string]struct{})
+	for i, line := range lines {
+		kind, name, ok := s.marker(line)
+		if !ok {
+			continue
+		}
+		switch kind {
+		case KeepBeginMarker:
+			if open != nil {
+				return nil, newError(i+1, fmt.Sprintf("nested %q, region begins at line %d", KeepBeginMarker, open.begin+1))
+			}
+			if _, exists := names[name]; exists && name != "" {
+				return nil, newError(i+1, fmt.Sprintf("duplicate region %q", name))
+			}
+			names[name] = struct{}{}
+			open = &keepRegion{name: name, begin: i}
+		case KeepEndMarker:
+			if open == nil {
+				return nil, newError(i+1, fmt.Sprintf("%q without %q", KeepEndMarker, KeepBeginMarker))
+			}
+			open.end = i
+			regions = append(regions, *open)
+			open = nil
+		}
+	}
+	if open != nil {
+		return nil, newError(open.begin+1, fmt.Sprintf("%q without %q", KeepBeginMarker, KeepEndMarker))
+	}
+	return regions, nil
+}
+
+// applyKeep 把当前文件保护区域内的内容替换到新内容的对应区域,当前文件没有保护区域时返回新内容
+func (s CommentSyntax) applyKeep(filename string, current []byte, content []byte) (merged []byte, err error) {
+	currentLines := strings.Split(string(current), "\n")
+	newError := func(source string) func(line int, reason string) error {
+		return func(line int, reason string) error {
+			return &KeepMarkerError{Filename: filename, Source: source, Line: line, Reason: reason}
+		}
+	}
+	currentRegions, err := s.keepRegions(currentLines, newError("current"))
+	if err != nil {
+		return nil, err
+	}
+	contentLines := strings.Split(string(content), "\n")
+	contentRegions, err := s.keepRegions(contentLines, newError("new"))
+	if err != nil {
+		return nil, err
+	}
+	if len(currentRegions) == 0 {
+		return content, nil
+	}
+	named, unnamed := make(map[string]keepRegion), make([]keepRegion, 0)
+	for _, region := range currentRegions {
+		if region.name == "" {
+			unnamed = append(unnamed, region)
+			continue
+		}
+		named[region.name] = region
+	}
+	lines := make([]string, 0, len(contentLines))
+	pos, unnamedIndex := 0, 0
+	for _, region := range contentRegions {
+		keep, ok := named[region.name]
+		if region.name == "" {
+			ok = unnamedIndex < len(unnamed)
+			if ok {
+				keep = unnamed[unnamedIndex]
+				unnamedIndex++
+			}
+		}
+		if !ok { // 新增的保护区域,使用新内容
+			continue
+		}
+		delete(named, region.name)
+		lines = append(lines, contentLines[pos:region.begin+1]...)
+		lines = append(lines, currentLines[keep.begin+1:keep.end]...)
+		pos = region.end
+	}
+	lines = append(lines, contentLines[pos:]...)
+	if unnamedIndex < len(unnamed) {
+		keep := unnamed[unnamedIndex]
+		return nil, newError("current")(keep.begin+1, "region missing in new content")
+	}
+	if len(named) > 0 {
+		missing := make([]keepRegion, 0, len(named))
+		for _, keep := range named {
+			missing = append(missing, keep)
+		}
+		sort.Slice(missing, func(i, j int) bool { return missing[i].begin < missing[j].begin })
+		return nil, newError("current")(missing[0].begin+1, fmt.Sprintf("region %q missing in new content", missing[0].name))
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// keepRegions 写入文件前,把工作区当前文件保护区域内的内容带入新内容,文件不存在或扩展名未配置注释语法时不处理
+func (rc *Repository) keepRegions(filename string, content []byte) (merged []byte, err error) {
+	syntax, ok := rc._client.commentSyntax(filename)
+	if !ok {
+		return content, nil
+	}
+	current, err := rc.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return content, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return syntax.applyKeep(filename, current, content)
+}
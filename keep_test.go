@@ -0,0 +1,59 @@
+package gitauto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepRegions(t *testing.T) {
+	goSyntax := DefaultCommentSyntaxes[".go"]
+	current := "package a\n// gitauto:keep begin\nfunc human() {}\n// gitauto:keep end\nfunc old() {}\n\t// gitauto:keep begin imports\nimport \"human\"\n// gitauto:keep end\n"
+	content := "package a\n// gitauto:keep begin imports\n// gitauto:keep end\n// gitauto:keep begin\n// gitauto:keep end\nfunc generated() {}\n"
+	merged, err := goSyntax.applyKeep("a.go", []byte(current), []byte(content))
+	require.NoError(t, err)
+	assert.Equal(t, "package a\n// gitauto:keep begin imports\nimport \"human\"\n// gitauto:keep end\n// gitauto:keep begin\nfunc human() {}\n// gitauto:keep end\nfunc generated() {}\n", string(merged))
+
+	t.Run("html comment", func(t *testing.T) {
+		md := DefaultCommentSyntaxes[".md"]
+		merged, err := md.applyKeep("a.md", []byte("# t\n<!-- gitauto:keep begin -->\nhuman\n<!-- gitauto:keep end -->\n"), []byte("# T\n<!-- gitauto:keep begin -->\n<!-- gitauto:keep end -->\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "# T\n<!-- gitauto:keep begin -->\nhuman\n<!-- gitauto:keep end -->\n", string(merged))
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		cases := map[string][2]string{
+			"missing end":        {"// gitauto:keep begin\nx\n", "a\n"},
+			"end without begin":  {"a\n", "// gitauto:keep end\n"},
+			"nested":             {"// gitauto:keep begin\n// gitauto:keep begin\n// gitauto:keep end\n", "a\n"},
+			"missing in content": {"// gitauto:keep begin x\nhuman\n// gitauto:keep end\n", "a\n"},
+		}
+		for name, c := range cases {
+			_, err := goSyntax.applyKeep("a.go", []byte(c[0]), []byte(c[1]))
+			require.ErrorIs(t, err, ErrKeepMarker, name)
+		}
+	})
+
+	t.Run("AddReplaceFileToStage", func(t *testing.T) {
+		rc := newLocalRepository(t, map[string]string{
+			"a.tpl": "{# gitauto:keep begin #}\nhuman\n{# gitauto:keep end #}\n",
+			"b.txt": "// gitauto:keep begin\nhuman\n// gitauto:keep end\n",
+		})
+		rc._client = NewClient(WithCommentSyntax(".TPL", CommentSyntax{Prefix: "{#", Suffix: "#}"}))
+		err := rc.AddReplaceFileToStage("a.tpl", []byte("{# gitauto:keep begin #}\n{# gitauto:keep end #}\nrobot\n"))
+		require.NoError(t, err)
+		b, err := rc.ReadFile("a.tpl")
+		require.NoError(t, err)
+		assert.Equal(t, "{# gitauto:keep begin #}\nhuman\n{# gitauto:keep end #}\nrobot\n", string(b))
+
+		err = rc.AddReplaceFileToStage("b.txt", []byte("robot\n")) // 未配置注释语法的扩展名不处理
+		require.NoError(t, err)
+		b, err = rc.ReadFile("b.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "robot\n", string(b))
+
+		err = rc.AddReplaceFileToStage("a.tpl", []byte("robot\n"))
+		require.ErrorIs(t, err, ErrKeepMarker)
+	})
+}
@@ -0,0 +1,255 @@
+package gitauto
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LockDirName 锁文件所在目录(位于工作根目录下)
+const LockDirName = ".lock"
+
+// LockRetryInterval 获取文件锁失败后的重试间隔
+var LockRetryInterval = 100 * time.Millisecond
+
+// StaleLockPeriod 锁文件超过该时长未刷新视为进程异常退出遗留的锁,可被抢占;持有期间每 StaleLockPeriod/3 刷新一次修改时间
+var StaleLockPeriod = 10 * time.Minute
+
+// ErrLockLost 释放文件锁时锁文件已不存在或已被其它持有者抢占(如本进程长时间挂起被判为过期)
+var ErrLockLost = errors.New("file lock lost")
+
+// fileLock 基于 O_EXCL 创建文件实现的跨进程锁,同一主机多个进程共享工作目录时使用;
+// 锁文件内容为持有者标识(进程号、时间、随机数),释放、抢占前比较标识,不会删除其它持有者的锁
+type fileLock struct {
+	path  string
+	token string        // 本次持有的标识,未持有时为空
+	stop  chan struct{} // 停止刷新修改时间
+	done  chan struct{}
+}
+
+func newFileLock(root string, workDir string) (fl *fileLock) {
+	name := fmt.Sprintf("%x.lock", sha1.Sum([]byte(workDir)))
+	fl = &fileLock{
+		path: filepath.Join(root, LockDirName, name),
+	}
+	return fl
+}
+
+// Lock 获取文件锁,直到成功或ctx结束;持有期间后台定期刷新锁文件修改时间,Unlock 时停止
+func (fl *fileLock) Lock(ctx context.Context) (err error) {
+	err = os.MkdirAll(filepath.Dir(fl.path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	for {
+		ok, err := fl.tryLock()
+		if err != nil {
+			return err
+		}
+		if ok {
+			if interval := StaleLockPeriod / 3; interval > 0 {
+				fl.stop, fl.done = make(chan struct{}), make(chan struct{})
+				go fl.refresh(fl.token, interval, fl.stop, fl.done)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.WithMessagef(ctx.Err(), "wait lock %s", fl.path)
+		case <-time.After(LockRetryInterval):
+		}
+	}
+}
+
+// tryLock 尝试获取一次文件锁,遇到过期的锁文件时删除,下次重试时获取
+func (fl *fileLock) tryLock() (ok bool, err error) {
+	token, err := newLockToken()
+	if err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(fl.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err == nil {
+		_, err = f.WriteString(token)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(fl.path)
+			return false, err
+		}
+		fl.token = token
+		return true, nil
+	}
+	if !os.IsExist(err) {
+		return false, err
+	}
+	err = fl.breakStale()
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// breakStale 删除过期的锁文件:先获取 .break 锁,再确认锁文件标识与判断过期时一致,
+// 避免多个进程同时判断过期后,后删除的进程删掉其它进程刚创建的锁
+func (fl *fileLock) breakStale() (err error) {
+	token, stale, err := fl.stale(fl.path)
+	if err != nil || !stale {
+		return err
+	}
+	breakPath := fl.path + ".break"
+	f, err := os.OpenFile(breakPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) { // 其它进程正在删除,或删除时异常退出遗留
+		if _, breakStale, _ := fl.stale(breakPath); breakStale {
+			_ = os.Remove(breakPath)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	defer os.Remove(breakPath)
+	current, stale, err := fl.stale(fl.path)
+	if err != nil || !stale || current != token {
+		return err
+	}
+	err = os.Remove(fl.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// stale 读取文件内容并判断是否超过 StaleLockPeriod 未修改,文件不存在时不过期
+func (fl *fileLock) stale(path string) (token string, stale bool, err error) {
+	s, err := os.Stat(path)
+	if os.IsNotExist(err) { // 其它进程刚释放
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(b), time.Since(s.ModTime()) > StaleLockPeriod, nil
+}
+
+// refresh 定期刷新锁文件修改时间,锁文件已被其它持有者抢占时停止
+func (fl *fileLock) refresh(token string, interval time.Duration, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b, err := os.ReadFile(fl.path)
+			if err != nil || string(b) != token {
+				return
+			}
+			now := time.Now()
+			_ = os.Chtimes(fl.path, now, now)
+		}
+	}
+}
+
+// Unlock 停止刷新并删除锁文件,锁文件不是本次持有时不删除,返回 ErrLockLost
+func (fl *fileLock) Unlock() (err error) {
+	if fl.stop != nil {
+		close(fl.stop)
+		<-fl.done
+		fl.stop, fl.done = nil, nil
+	}
+	token := fl.token
+	fl.token = ""
+	b, err := os.ReadFile(fl.path)
+	if os.IsNotExist(err) {
+		return errors.WithMessagef(ErrLockLost, "lock %s removed", fl.path)
+	}
+	if err != nil {
+		return err
+	}
+	if token == "" || string(b) != token {
+		return errors.WithMessagef(ErrLockLost, "lock %s held by %s", fl.path, strings.TrimSpace(string(b)))
+	}
+	err = os.Remove(fl.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// newLockToken 持有者标识:进程号、时间和随机数
+func newLockToken() (token string, err error) {
+	nonce := make([]byte, 8)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	token = fmt.Sprintf("%d %s %x\n", os.Getpid(), time.Now().Format(time.RFC3339), nonce)
+	return token, nil
+}
+
+// repositoryEntry 仓库注册表条目,mu 保证同一工作目录只打开(clone)一次
+type repositoryEntry struct {
+	mu sync.Mutex
+	rc *Repository
+}
+
+// Lock 独占仓库工作目录:先获取进程内互斥锁,再获取磁盘锁文件。锁不可重入,AddReplaceFileToStage、CommitWithPush 等方法
+// 各自持有该锁,持有 Lock 期间不能调用;需要把多步修改(写入后提交)作为一个整体时使用 WithSession 或 NewChangeSet
+func (rc *Repository) Lock(ctx context.Context) (err error) {
+	select {
+	case rc._sem <- struct{}{}:
+	case <-ctx.Done():
+		return errors.WithMessagef(ctx.Err(), "wait lock %s", rc._workDir)
+	}
+	if rc._fileLock == nil { // 内存仓库
+		return nil
+	}
+	err = rc._fileLock.Lock(ctx)
+	if err != nil {
+		<-rc._sem
+		return err
+	}
+	return nil
+}
+
+// Unlock 释放 Lock 获取的锁
+func (rc *Repository) Unlock() (err error) {
+	if rc._fileLock != nil {
+		err = rc._fileLock.Unlock()
+	}
+	<-rc._sem
+	return err
+}
+
+// WithLock 持有仓库锁执行fn,fn 内同样不能调用自行加锁的方法,见 WithSession
+func (rc *Repository) WithLock(ctx context.Context, fn func() error) (err error) {
+	err = rc.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		unlockErr := rc.Unlock()
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+	return fn()
+}
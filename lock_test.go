@@ -0,0 +1,129 @@
+package gitauto
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLock(t *testing.T) {
+	root := t.TempDir()
+	workDir := WorkDirConfig{Root: root}.WorkDir("ssh://git@gitea.programmerfamily.com:2221/go/coupon.git")
+	fl := newFileLock(root, workDir)
+	err := fl.Lock(context.Background())
+	require.NoError(t, err)
+
+	t.Run("locked by other", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		err := newFileLock(root, workDir).Lock(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		stalePeriod := StaleLockPeriod
+		StaleLockPeriod = 0
+		defer func() { StaleLockPeriod = stalePeriod }()
+		other := newFileLock(root, workDir)
+		err := other.Lock(context.Background())
+		require.NoError(t, err)
+		err = fl.Unlock()
+		require.ErrorIs(t, err, ErrLockLost, "lock taken over by other must not be removed")
+		require.FileExists(t, other.path)
+		err = other.Unlock()
+		require.NoError(t, err)
+	})
+
+	t.Run("unlock without holding", func(t *testing.T) {
+		err := fl.Lock(context.Background())
+		require.NoError(t, err)
+		err = newFileLock(root, workDir).Unlock()
+		require.ErrorIs(t, err, ErrLockLost)
+		require.FileExists(t, fl.path)
+		err = fl.Unlock()
+		require.NoError(t, err)
+		require.NoFileExists(t, fl.path)
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		stalePeriod := StaleLockPeriod
+		StaleLockPeriod = 300 * time.Millisecond
+		defer func() { StaleLockPeriod = stalePeriod }()
+		err := fl.Lock(context.Background())
+		require.NoError(t, err)
+		time.Sleep(2 * StaleLockPeriod)
+		ok, err := newFileLock(root, workDir).tryLock()
+		require.NoError(t, err)
+		assert.False(t, ok, "held lock is refreshed and never stale")
+		err = fl.Unlock()
+		require.NoError(t, err)
+	})
+}
+
+func TestRepositoryLockConcurrent(t *testing.T) {
+	root := t.TempDir()
+	remotePath := newBareRemote(t, map[string]string{"README.md": "r"})
+	// 两个 Client 共享工作根目录,模拟多个进程同时操作同一工作目录
+	clients := []*Client{
+		NewClient(WithClientWorkDir(WorkDirConfig{Root: root})),
+		NewClient(WithClientWorkDir(WorkDirConfig{Root: root})),
+	}
+	user := User{Name: "robot", Email: "robot@example.com"}
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = func() error {
+				rc, err := clients[i%len(clients)].NewRepository(remotePath)
+				if err != nil {
+					return err
+				}
+				return rc.WithSession(context.Background(), func(s *Session) error {
+					err := s.AddReplaceFileToStage(fmt.Sprintf("doc/%d.md", i), []byte(fmt.Sprint(i)))
+					if err != nil {
+						return err
+					}
+					return s.CommitWithPush(context.Background(), fmt.Sprintf("add %d", i), user) // git add . 只会包含本次写入
+				})
+			}()
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		require.NoError(t, err, "goroutine %d", i)
+	}
+
+	r, err := git.PlainOpen(remotePath)
+	require.NoError(t, err)
+	iter, err := r.Log(&git.LogOptions{})
+	require.NoError(t, err)
+	commits := 0
+	err = iter.ForEach(func(c *object.Commit) error {
+		if c.NumParents() == 0 {
+			return nil
+		}
+		commits++
+		stats, err := c.StatsContext(context.Background())
+		require.NoError(t, err)
+		require.Len(t, stats, 1, c.Message)
+		assert.Equal(t, "doc/"+strings.TrimPrefix(c.Message, "add ")+".md", stats[0].Name, "each commit contains only its own file")
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, n, commits)
+	locks, err := filepath.Glob(filepath.Join(root, LockDirName, "*"))
+	require.NoError(t, err)
+	assert.Empty(t, locks)
+}
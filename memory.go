@@ -0,0 +1,90 @@
+package gitauto
+
+import (
+	"context"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/pkg/errors"
+)
+
+// memoryKeyPrefix 内存仓库在注册表中的键前缀,与同一远程仓库的磁盘工作目录区分
+const memoryKeyPrefix = "memory:"
+
+// ErrMemoryLimitExceeded 内存仓库clone的对象总大小超过限制
+var ErrMemoryLimitExceeded = errors.New("memory repository size limit exceeded")
+
+// repositoryOptions NewRepository 可选配置
+type repositoryOptions struct {
+	memory      bool
+	memoryLimit int64
+	clone       CloneOptions
+	workDir     WorkDirConfig
+}
+
+// RepositoryOption NewRepository 可选配置
+type RepositoryOption func(o *repositoryOptions)
+
+// WithMemoryStorage 仓库对象和工作区都保存在内存中,不在 RobotWorkDir 下生成工作目录,适合只读查询;
+// maxSize 为clone对象总大小上限(字节),超过时返回 ErrMemoryLimitExceeded,0 表示不限制
+func WithMemoryStorage(maxSize int64) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.memory = true
+		o.memoryLimit = maxSize
+	}
+}
+
+func newRepositoryOptions(workDir WorkDirConfig, opts ...RepositoryOption) (o *repositoryOptions) {
+	o = &repositoryOptions{
+		workDir: workDir,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// limitedMemoryStorage 限制对象总大小的内存存储
+type limitedMemoryStorage struct {
+	*memory.Storage
+	limit int64
+	size  int64
+}
+
+func (s *limitedMemoryStorage) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	if s.limit > 0 {
+		s.size += obj.Size()
+		if s.size > s.limit {
+			return plumbing.ZeroHash, errors.WithMessagef(ErrMemoryLimitExceeded, "limit %d bytes", s.limit)
+		}
+	}
+	return s.Storage.SetEncodedObject(obj)
+}
+
+// cloneToMemory clone远程仓库到内存
+func (c *Client) cloneToMemory(ctx context.Context, remoteUrl string, limit int64, co CloneOptions) (r *git.Repository, err error) {
+	remoteUrl, _ = splitRemoteUrlAndRepositoryFilename(remoteUrl)
+	remoteUrlObj, err := parseRemoteUrl(remoteUrl)
+	if err != nil {
+		return nil, err
+	}
+	auth, diagnostics, err := c.resolveAuth(ctx, remoteUrlObj)
+	if err != nil {
+		return nil, err
+	}
+	storage := &limitedMemoryStorage{
+		Storage: memory.NewStorage(),
+		limit:   limit,
+	}
+	r, err = git.CloneContext(ctx, storage, memfs.New(), co.gitCloneOptions(remoteUrl, auth))
+	if err != nil {
+		return nil, wrapAuthError(err, diagnostics)
+	}
+	err = co.sparseCheckout(r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,43 @@
+package gitauto
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	root := useRobotWorkDir(t)
+	remotePath := newBareRemote(t, map[string]string{"doc/a.md": "line1\nline2\n"})
+
+	b, err := ReadFile(remotePath+"/doc/a.md", WithMemoryStorage(0))
+	require.NoError(t, err)
+	assert.Equal(t, "line1\nline2\n", string(b))
+
+	rc, err := NewRepositoryContext(context.Background(), remotePath, WithMemoryStorage(0))
+	require.NoError(t, err)
+	exists, err := rc.Exists("doc/a.md")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	lineAuthors, err := rc.GetLineCodeAuthor("doc/a.md")
+	require.NoError(t, err)
+	assert.Len(t, lineAuthors, 2)
+	err = rc.AddReplaceFileToStage("doc/b.md", []byte("b"))
+	require.NoError(t, err)
+	b, err = rc.ReadFile("doc/b.md")
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(b))
+
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "memory repository must not write work dir")
+
+	t.Run("size limit", func(t *testing.T) {
+		limitedRemote := newBareRemote(t, map[string]string{"doc/a.md": "line1\nline2\n"})
+		_, err := NewRepository(limitedRemote, WithMemoryStorage(10))
+		require.ErrorIs(t, err, ErrMemoryLimitExceeded)
+	})
+}
@@ -0,0 +1,342 @@
+package gitauto
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+)
+
+// PullRequest 创建合并请求的参数
+type PullRequest struct {
+	Owner string // 仓库所属用户或组织
+	Repo  string // 仓库名,不含 .git
+	Title string
+	Body  string
+	Head  string // 源分支
+	Base  string // 目标分支
+}
+
+// PullRequestResult 创建合并请求的结果
+type PullRequestResult struct {
+	Number int64
+	URL    string
+}
+
+// PullRequestProvider 代码托管平台创建合并请求的接口
+type PullRequestProvider interface {
+	CreatePullRequest(ctx context.Context, pr PullRequest) (result *PullRequestResult, err error)
+}
+
+// GiteaProvider 通过 Gitea REST API 创建合并请求
+type GiteaProvider struct {
+	BaseURL    string // 如 https://gitea.programmerfamily.com
+	Token      string
+	HTTPClient *http.Client
+}
+
+func (p *GiteaProvider) CreatePullRequest(ctx context.Context, pr PullRequest) (result *PullRequestResult, err error) {
+	api := fmt.Sprintf("%s/api/v1/repos/%s/%s/pulls", strings.TrimRight(p.BaseURL, "/"), pr.Owner, pr.Repo)
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("token %s", p.Token))
+	return createPullRequest(ctx, p.HTTPClient, api, header, pr)
+}
+
+// GitHubAPI GitHub REST API 默认地址
+const GitHubAPI = "https://api.github.com"
+
+// GitHubProvider 通过 GitHub REST API 创建合并请求
+type GitHubProvider struct {
+	BaseURL    string // 为空时使用 GitHubAPI,GitHub Enterprise 为 https://host/api/v3
+	Token      string
+	HTTPClient *http.Client
+}
+
+func (p *GitHubProvider) CreatePullRequest(ctx context.Context, pr PullRequest) (result *PullRequestResult, err error) {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = GitHubAPI
+	}
+	api := fmt.Sprintf("%s/repos/%s/%s/pulls", strings.TrimRight(baseURL, "/"), pr.Owner, pr.Repo)
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", p.Token))
+	header.Set("Accept", "application/vnd.github+json")
+	return createPullRequest(ctx, p.HTTPClient, api, header, pr)
+}
+
+// createPullRequest Gitea 与 GitHub 创建合并请求的请求体、响应体格式一致
+func createPullRequest(ctx context.Context, client *http.Client, api string, header http.Header, pr PullRequest) (result *PullRequestResult, err error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	reqBody, err := json.Marshal(map[string]string{
+		"title": pr.Title,
+		"body":  pr.Body,
+		"head":  pr.Head,
+		"base":  pr.Base,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	rspBody, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusCreated && rsp.StatusCode != http.StatusOK {
+		err = errors.Errorf("create pull request %s: http status %d: %s", api, rsp.StatusCode, string(rspBody))
+		return nil, err
+	}
+	var out struct {
+		Number  int64  `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	err = json.Unmarshal(rspBody, &out)
+	if err != nil {
+		return nil, err
+	}
+	result = &PullRequestResult{
+		Number: out.Number,
+		URL:    out.HTMLURL,
+	}
+	return result, nil
+}
+
+// DefaultProposeBranchPrefix 提议分支默认前缀
+const DefaultProposeBranchPrefix = "gitauto"
+
+// ProposeOptions 以合并请求方式提交变更的参数
+type ProposeOptions struct {
+	BaseBranch   string // 合并请求目标分支,默认当前分支
+	BranchPrefix string // 新分支名前缀,默认 DefaultProposeBranchPrefix
+	CommitMsg    string
+	User         User
+	Title        string // 合并请求标题,默认 CommitMsg
+	Body         string
+	Owner        string // 默认从远程地址解析
+	Repo         string // 默认从远程地址解析
+	Provider     PullRequestProvider
+}
+
+// Propose 从目标分支的远程最新提交创建唯一命名的分支,只提交程序写入过的文件并推送该分支,然后创建合并请求。
+// 完成后工作区切换回原分支;工作区存在程序写入以外的变更时返回 *UnrelatedChangesError,不切换分支。持有仓库锁执行
+func (rc *Repository) Propose(ctx context.Context, opts ProposeOptions) (result *PullRequestResult, err error) {
+	err = rc.WithLock(ctx, func() (err error) {
+		result, err = rc.propose(ctx, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (rc *Repository) propose(ctx context.Context, opts ProposeOptions) (result *PullRequestResult, err error) {
+	err = rc.checkBranch()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Provider == nil {
+		err = errors.Errorf("Propose: Provider not be nil")
+		return nil, err
+	}
+	if opts.BaseBranch == "" {
+		opts.BaseBranch = rc.LocalBranch
+	}
+	if opts.BranchPrefix == "" {
+		opts.BranchPrefix = DefaultProposeBranchPrefix
+	}
+	if opts.Title == "" {
+		opts.Title = opts.CommitMsg
+	}
+	if opts.Owner == "" || opts.Repo == "" {
+		owner, repo, err := rc.ownerAndRepo()
+		if err != nil {
+			return nil, err
+		}
+		if opts.Owner == "" {
+			opts.Owner = owner
+		}
+		if opts.Repo == "" {
+			opts.Repo = repo
+		}
+	}
+	branchName, err := proposeBranchName(opts.BranchPrefix, rc._client.now())
+	if err != nil {
+		return nil, err
+	}
+	err = rc.pushProposeBranch(ctx, branchName, opts)
+	if err != nil {
+		return nil, err
+	}
+	pr := PullRequest{
+		Owner: opts.Owner,
+		Repo:  opts.Repo,
+		Title: opts.Title,
+		Body:  opts.Body,
+		Head:  branchName,
+		Base:  opts.BaseBranch,
+	}
+	return opts.Provider.CreatePullRequest(ctx, pr)
+}
+
+// pushProposeBranch 在基于目标分支远程最新提交的新分支上提交并推送,结束后切换回原分支;
+// 程序写入的文件先保存,新分支强制检出远程最新提交后再写回,避免原分支的暂存区混入新分支
+func (rc *Repository) pushProposeBranch(ctx context.Context, branchName string, opts ProposeOptions) (err error) {
+	r := rc._r
+	err = rc.FetchContext(ctx)
+	if err != nil {
+		return err
+	}
+	baseRef, err := r.Reference(plumbing.NewRemoteReferenceName(rc.RemoteName, opts.BaseBranch), true)
+	if err != nil {
+		return errors.WithMessagef(err, "base branch %s", opts.BaseBranch)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	err = checkUnrelatedChanges(w, rc.touchedPaths()) // 切换分支会强制检出,其它变更会丢失
+	if err != nil {
+		return err
+	}
+	touched, err := rc.snapshotTouched(w)
+	if err != nil {
+		return err
+	}
+	originBranch := rc.LocalBranch
+	err = w.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branchName),
+		Hash:   baseRef.Hash(),
+		Create: true,
+		Force:  true,
+	})
+	if err != nil {
+		return err
+	}
+	rc.LocalBranch = branchName
+	committed := false
+	defer func() {
+		rc.LocalBranch = originBranch
+		checkoutErr := w.Checkout(&git.CheckoutOptions{
+			Branch: plumbing.NewBranchReferenceName(originBranch),
+			Force:  true,
+		})
+		if checkoutErr == nil && !committed { // 未提交时变更带回原分支
+			checkoutErr = restoreTouched(w, touched)
+		}
+		if err == nil {
+			err = checkoutErr
+		}
+	}()
+	err = restoreTouched(w, touched)
+	if err != nil {
+		return err
+	}
+	committed, err = rc.commitTouched(opts.CommitMsg, opts.User)
+	if err != nil {
+		return err
+	}
+	if !committed {
+		err = errors.Errorf("Propose: nothing to commit")
+		return err
+	}
+	return rc.push(ctx)
+}
+
+// snapshotTouched 保存程序写入过的文件内容,已删除的文件内容为 nil
+func (rc *Repository) snapshotTouched(w *git.Worktree) (snapshot map[string][]byte, err error) {
+	snapshot = make(map[string][]byte)
+	for path := range rc.touchedPaths() {
+		b, err := util.ReadFile(w.Filesystem, path)
+		if os.IsNotExist(err) {
+			snapshot[path] = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshot[path] = b
+	}
+	return snapshot, nil
+}
+
+// restoreTouched 把保存的内容写回工作区,内容为 nil 的文件删除
+func restoreTouched(w *git.Worktree, snapshot map[string][]byte) (err error) {
+	for path, content := range snapshot {
+		if content == nil {
+			err = w.Filesystem.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		err = util.WriteFile(w.Filesystem, path, content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownerAndRepo 从远程地址解析仓库所属用户(组织)和仓库名
+func (rc *Repository) ownerAndRepo() (owner string, repo string, err error) {
+	remote, err := rc._r.Remote(rc.RemoteName)
+	if err != nil {
+		return "", "", err
+	}
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		err = errors.Errorf("remote %s has no url", rc.RemoteName)
+		return "", "", err
+	}
+	u, err := parseRemoteUrl(urls[0])
+	if err != nil {
+		return "", "", err
+	}
+	if u == nil {
+		err = errors.Errorf("invalid remote url %s", urls[0])
+		return "", "", err
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 {
+		err = errors.Errorf("can not parse owner and repo from %s", urls[0])
+		return "", "", err
+	}
+	owner = segments[len(segments)-2]
+	repo = strings.TrimSuffix(segments[len(segments)-1], git.GitDirName)
+	return owner, repo, nil
+}
+
+// proposeBranchName 生成唯一分支名,如 gitauto/20230301150405-1a2b3c4d
+func proposeBranchName(prefix string, now time.Time) (branchName string, err error) {
+	b := make([]byte, 4)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	branchName = fmt.Sprintf("%s/%s-%s", strings.TrimRight(prefix, "/"), now.Format("20060102150405"), hex.EncodeToString(b))
+	return branchName, nil
+}
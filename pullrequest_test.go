@@ -0,0 +1,170 @@
+package gitauto
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+// newPullRequestStub 模拟 Gitea/GitHub 创建合并请求接口,记录收到的请求
+func newPullRequestStub(t *testing.T, path string, authorization string) (server *httptest.Server, received *PullRequest) {
+	received = &PullRequest{}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, path, r.URL.Path)
+		require.Equal(t, authorization, r.Header.Get("Authorization"))
+		var body map[string]string
+		err := json.NewDecoder(r.Body).Decode(&body)
+		require.NoError(t, err)
+		received.Title, received.Body, received.Head, received.Base = body["title"], body["body"], body["head"], body["base"]
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"number":7,"html_url":"https://example.com/pulls/7"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func TestPullRequestProvider(t *testing.T) {
+	pr := PullRequest{Owner: "go", Repo: "coupon", Title: "gen", Head: "gitauto/x", Base: "master"}
+	t.Run("gitea", func(t *testing.T) {
+		server, received := newPullRequestStub(t, "/api/v1/repos/go/coupon/pulls", "token secret")
+		provider := &GiteaProvider{BaseURL: server.URL, Token: "secret"}
+		result, err := provider.CreatePullRequest(context.Background(), pr)
+		require.NoError(t, err)
+		require.Equal(t, int64(7), result.Number)
+		require.Equal(t, "https://example.com/pulls/7", result.URL)
+		require.Equal(t, "gitauto/x", received.Head)
+	})
+	t.Run("github", func(t *testing.T) {
+		server, received := newPullRequestStub(t, "/repos/go/coupon/pulls", "Bearer secret")
+		provider := &GitHubProvider{BaseURL: server.URL, Token: "secret"}
+		result, err := provider.CreatePullRequest(context.Background(), pr)
+		require.NoError(t, err)
+		require.Equal(t, int64(7), result.Number)
+		require.Equal(t, "master", received.Base)
+	})
+	t.Run("error status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}))
+		defer server.Close()
+		provider := &GitHubProvider{BaseURL: server.URL}
+		_, err := provider.CreatePullRequest(context.Background(), pr)
+		require.Error(t, err)
+	})
+}
+
+func TestPropose(t *testing.T) {
+	remotePath := newBareRemote(t, map[string]string{"a.md": "a"})
+	rc := cloneRemote(t, remotePath)
+	server, received := newPullRequestStub(t, "/api/v1/repos/go/coupon/pulls", "token secret")
+
+	err := rc.AddReplaceFileToStage("a.md", []byte("generated"))
+	require.NoError(t, err)
+	result, err := rc.Propose(context.Background(), ProposeOptions{
+		CommitMsg: "generate a.md",
+		User:      User{Name: "robot", Email: "robot@example.com"},
+		Owner:     "go",
+		Repo:      "coupon",
+		Provider:  &GiteaProvider{BaseURL: server.URL, Token: "secret"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(7), result.Number)
+	require.True(t, strings.HasPrefix(received.Head, DefaultProposeBranchPrefix+"/"))
+	require.Equal(t, "master", received.Base)
+	require.Equal(t, "master", rc.LocalBranch)
+
+	b, err := rc.ReadFile("a.md")
+	require.NoError(t, err)
+	require.Equal(t, "a", string(b))
+	remoteRef, err := rc._r.Reference(plumbing.NewRemoteReferenceName(rc.RemoteName, received.Head), true)
+	require.NoError(t, err)
+	remoteCommit, err := rc._r.CommitObject(remoteRef.Hash())
+	require.NoError(t, err)
+	require.Equal(t, "generate a.md", remoteCommit.Message)
+}
+
+func TestProposeRemoteAdvanced(t *testing.T) {
+	remotePath := newBareRemote(t, map[string]string{"a.md": "a", "b.md": "b", "c.md": "c"})
+	rc := cloneRemote(t, remotePath)
+	other := cloneRemote(t, remotePath)
+	user := User{Name: "robot", Email: "robot@example.com"}
+	err := other.AddReplaceFileToStage("b.md", []byte("b from other"))
+	require.NoError(t, err)
+	err = other.CommitWithPush("other", user)
+	require.NoError(t, err)
+	server, received := newPullRequestStub(t, "/api/v1/repos/go/coupon/pulls", "token secret")
+
+	err = rc.AddReplaceFileToStage("a.md", []byte("generated"))
+	require.NoError(t, err)
+	err = rc.DeleteFile("c.md")
+	require.NoError(t, err)
+	_, err = rc.Propose(context.Background(), ProposeOptions{
+		CommitMsg: "generate a.md",
+		User:      user,
+		Owner:     "go",
+		Repo:      "coupon",
+		Provider:  &GiteaProvider{BaseURL: server.URL, Token: "secret"},
+	})
+	require.NoError(t, err)
+
+	remoteRef, err := rc._r.Reference(plumbing.NewRemoteReferenceName(rc.RemoteName, received.Head), true)
+	require.NoError(t, err)
+	remoteCommit, err := rc._r.CommitObject(remoteRef.Hash())
+	require.NoError(t, err)
+	parent, err := remoteCommit.Parent(0)
+	require.NoError(t, err)
+	require.Equal(t, "other", strings.TrimSpace(parent.Message), "proposal branch starts at the advanced remote tip")
+	files := map[string]string{"a.md": "generated", "b.md": "b from other"}
+	for name, content := range files {
+		f, err := remoteCommit.File(name)
+		require.NoError(t, err)
+		got, err := f.Contents()
+		require.NoError(t, err)
+		require.Equal(t, content, got, name)
+	}
+	_, err = remoteCommit.File("c.md")
+	require.Error(t, err, "deleted file is not carried over")
+
+	w, err := rc._r.Worktree()
+	require.NoError(t, err)
+	status, err := w.Status()
+	require.NoError(t, err)
+	require.True(t, status.IsClean(), "original branch is left clean: %s", status)
+}
+
+func TestProposeUnrelatedChanges(t *testing.T) {
+	remotePath := newBareRemote(t, map[string]string{"a.md": "a", "b.md": "b"})
+	rc := cloneRemote(t, remotePath)
+	server, received := newPullRequestStub(t, "/api/v1/repos/go/coupon/pulls", "token secret")
+
+	err := rc.AddReplaceFileToStage("a.md", []byte("generated"))
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(rc._workDir, "b.md"), []byte("b by human"), 0644)
+	require.NoError(t, err)
+	_, err = rc.Propose(context.Background(), ProposeOptions{
+		CommitMsg: "generate a.md",
+		User:      User{Name: "robot", Email: "robot@example.com"},
+		Owner:     "go",
+		Repo:      "coupon",
+		Provider:  &GiteaProvider{BaseURL: server.URL, Token: "secret"},
+	})
+	var unrelatedErr *UnrelatedChangesError
+	require.ErrorAs(t, err, &unrelatedErr)
+	require.Equal(t, []string{"b.md"}, unrelatedErr.Paths)
+	require.Empty(t, received.Head, "no pull request is created")
+	require.Equal(t, "master", rc.LocalBranch)
+	for name, content := range map[string]string{"a.md": "generated", "b.md": "b by human"} {
+		b, err := rc.ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, content, string(b), name)
+	}
+}
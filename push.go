@@ -0,0 +1,438 @@
+package gitauto
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/errors"
+)
+
+// PushRetry 推送时远程分支已有新提交的重试策略
+type PushRetry struct {
+	MaxRetries int           // 最大重试次数,0 不重试
+	Backoff    time.Duration // 首次重试前等待时长,之后每次翻倍
+}
+
+// DefaultPushRetry 新建 Repository 默认使用的重试策略
+var DefaultPushRetry = PushRetry{
+	MaxRetries: 3,
+	Backoff:    time.Second,
+}
+
+// ConflictError 本地提交与远程新提交修改了相同文件的同一区域(或一方删除了文件),无法自动变基
+type ConflictError struct {
+	Branch string
+	Files  []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("branch %s conflicts with remote: %s", e.Branch, strings.Join(e.Files, ","))
+}
+
+// push 拉取远程分支,必要时把本地提交变基到远程最新提交上再推送,远程在此期间又有新提交时按 PushRetry 重试,
+// 重试后仍被拒绝时返回包装 git.ErrNonFastForwardUpdate 的错误
+func (rc *Repository) push(ctx context.Context) (err error) {
+	r := rc._r
+	err = rc.checkBranch()
+	if err != nil {
+		return err
+	}
+	auth, u, err := rc.remoteAuth(ctx)
+	if err != nil {
+		return err
+	}
+	remoteURL := ""
+	if u != nil {
+		remoteURL = u.String()
+	}
+	branchName := rc.LocalBranch
+	refSpec := config.RefSpec(fmt.Sprintf("refs/heads/%s:refs/heads/%s", branchName, branchName))
+	backoff := rc.PushRetry.Backoff
+	for retry := 0; ; retry++ {
+		err = r.FetchContext(ctx, &git.FetchOptions{
+			RemoteName: rc.RemoteName,
+			Auth:       auth,
+		})
+		if errors.Is(err, git.NoErrAlreadyUpToDate) { //already up-to-date 为正常情况
+			err = nil
+		}
+		if err != nil {
+			return rc.wrapAuthError(err)
+		}
+		err = rc.rebaseOnRemote()
+		if err != nil {
+			return err
+		}
+		err = r.PushContext(ctx, &git.PushOptions{
+			RemoteName: rc.RemoteName,
+			Auth:       auth,
+			RemoteURL:  remoteURL,
+			RefSpecs: []config.RefSpec{
+				refSpec,
+			},
+		})
+		if errors.Is(err, git.NoErrAlreadyUpToDate) {
+			err = nil
+		}
+		if err == nil {
+			return nil
+		}
+		advanced, advancedErr := rc.remoteAdvanced(ctx, auth)
+		if advancedErr != nil || !advanced {
+			return rc.wrapAuthError(err)
+		}
+		if retry >= rc.PushRetry.MaxRetries {
+			return errors.WithMessagef(git.ErrNonFastForwardUpdate, "push %s after %d retries: %v", branchName, retry, err)
+		}
+		rc._client.logf("gitauto: push %s rejected, retry in %s", branchName, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// remoteAdvanced 推送失败后查询远程分支,远程分支的提交不是本地 HEAD 的祖先(推送期间有新提交)时为 true
+func (rc *Repository) remoteAdvanced(ctx context.Context, auth transport.AuthMethod) (advanced bool, err error) {
+	r := rc._r
+	remote, err := r.Remote(rc.RemoteName)
+	if err != nil {
+		return false, err
+	}
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return false, err
+	}
+	branch := plumbing.NewBranchReferenceName(rc.LocalBranch)
+	for _, ref := range refs {
+		if ref.Name() != branch {
+			continue
+		}
+		headRef, err := r.Head()
+		if err != nil {
+			return false, err
+		}
+		if ref.Hash() == headRef.Hash() {
+			return false, nil
+		}
+		remoteCommit, err := r.CommitObject(ref.Hash())
+		if errors.Is(err, plumbing.ErrObjectNotFound) { // 本地还没有远程的新提交
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		headCommit, err := r.CommitObject(headRef.Hash())
+		if err != nil {
+			return false, err
+		}
+		ancestor, err := remoteCommit.IsAncestor(headCommit)
+		if err != nil {
+			return false, err
+		}
+		return !ancestor, nil
+	}
+	return false, nil
+}
+
+// rebaseOnRemote 远程分支有本地没有的提交时,把本地提交逐个重放到远程最新提交之上;
+// 双方修改的同一文件按行三方合并(见 merge3),修改了同一区域或一方删除文件时返回 *ConflictError,工作区保持原样
+func (rc *Repository) rebaseOnRemote() (err error) {
+	r := rc._r
+	remoteRefName := plumbing.NewRemoteReferenceName(rc.RemoteName, rc.LocalBranch)
+	remoteRef, err := r.Reference(remoteRefName, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) { // 远程分支不存在,直接推送
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	headRef, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if headRef.Hash() == remoteRef.Hash() {
+		return nil
+	}
+	headCommit, err := r.CommitObject(headRef.Hash())
+	if err != nil {
+		return err
+	}
+	remoteCommit, err := r.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return err
+	}
+	fastForward, err := remoteCommit.IsAncestor(headCommit)
+	if err != nil {
+		return err
+	}
+	if fastForward {
+		return nil
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	behind, err := headCommit.IsAncestor(remoteCommit)
+	if err != nil {
+		return err
+	}
+	if behind { // 本地没有新提交,跟进远程即可
+		return w.Reset(&git.ResetOptions{Commit: remoteCommit.Hash, Mode: git.HardReset})
+	}
+
+	bases, err := headCommit.MergeBase(remoteCommit)
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		err = errors.Errorf("branch %s has no common ancestor with %s", rc.LocalBranch, remoteRefName)
+		return err
+	}
+	base := bases[0]
+	localChanges, err := changedFiles(base, headCommit)
+	if err != nil {
+		return err
+	}
+	remoteChanges, err := changedFiles(base, remoteCommit)
+	if err != nil {
+		return err
+	}
+	conflicts := make([]string, 0)
+	for name, hash := range localChanges {
+		remoteHash, ok := remoteChanges[name]
+		if !ok || remoteHash == hash {
+			continue
+		}
+		mergeable, err := canMergeFile(base, remoteCommit, headCommit, name)
+		if err != nil {
+			return err
+		}
+		if !mergeable {
+			conflicts = append(conflicts, name)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		err = &ConflictError{Branch: rc.LocalBranch, Files: conflicts}
+		return err
+	}
+
+	localCommits := make([]*object.Commit, 0) // 从旧到新
+	for c := headCommit; c.Hash != base.Hash; {
+		if c.NumParents() == 0 {
+			break
+		}
+		localCommits = append([]*object.Commit{c}, localCommits...)
+		c, err = c.Parent(0)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Reset(&git.ResetOptions{Commit: remoteCommit.Hash, Mode: git.HardReset})
+	if err != nil {
+		return err
+	}
+	for _, c := range localCommits {
+		err = replayCommit(w, c, rc._client.now())
+		var conflictErr *ConflictError
+		if errors.As(err, &conflictErr) {
+			conflictErr.Branch = rc.LocalBranch
+		}
+		if err != nil {
+			resetErr := w.Reset(&git.ResetOptions{Commit: headCommit.Hash, Mode: git.HardReset})
+			if resetErr != nil {
+				return errors.WithMessagef(err, "replay %s, reset back to %s failed: %v", c.Hash, headCommit.Hash, resetErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// canMergeFile 双方都修改(未删除)了文件,且按行三方合并没有冲突
+func canMergeFile(base *object.Commit, ours *object.Commit, theirs *object.Commit, name string) (ok bool, err error) {
+	baseContent, _, err := commitFileContent(base, name)
+	if err != nil {
+		return false, err
+	}
+	ourContent, ourExists, err := commitFileContent(ours, name)
+	if err != nil {
+		return false, err
+	}
+	theirContent, theirExists, err := commitFileContent(theirs, name)
+	if err != nil {
+		return false, err
+	}
+	if !ourExists || !theirExists {
+		return false, nil
+	}
+	_, conflicts := mergeText(baseContent, ourContent, theirContent)
+	return len(conflicts) == 0, nil
+}
+
+// commitFileContent 文件在提交中的内容,文件不存在时 exists 为 false
+func commitFileContent(c *object.Commit, name string) (content string, exists bool, err error) {
+	f, err := c.File(name)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	content, err = f.Contents()
+	if err != nil {
+		return "", false, err
+	}
+	return content, true, nil
+}
+
+// mergeText 按行三方合并文本,任一方以换行结尾时结果也以换行结尾
+func mergeText(base string, ours string, theirs string) (merged string, conflicts []ConflictHunk) {
+	lines, conflicts := merge3(splitLines(base), splitLines(ours), splitLines(theirs))
+	merged = strings.Join(lines, "\n")
+	if len(lines) > 0 && (strings.HasSuffix(ours, "\n") || strings.HasSuffix(theirs, "\n")) {
+		merged += "\n"
+	}
+	return merged, conflicts
+}
+
+// replayCommit 把提交c相对第一个父提交的文件变更应用到工作区并以原作者、原提交信息重新提交;
+// 工作区中的文件已不同于父提交(远程也修改了)时按行三方合并,无法合并时返回 *ConflictError
+func replayCommit(w *git.Worktree, c *object.Commit, when time.Time) (err error) {
+	parent, err := c.Parent(0)
+	if err != nil {
+		return err
+	}
+	changes, err := changedFiles(parent, c)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err = replayFile(w, parent, c, name)
+		if err != nil {
+			return err
+		}
+		_, err = w.Add(name)
+		if err != nil {
+			return err
+		}
+	}
+	author := c.Author
+	committer := c.Committer
+	committer.When = when
+	_, err = w.Commit(c.Message, &git.CommitOptions{
+		Author:    &author,
+		Committer: &committer,
+	})
+	if errors.Is(err, git.ErrEmptyCommit) { // 远程已包含相同变更
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// replayFile 把提交c对文件的修改应用到工作区
+func replayFile(w *git.Worktree, parent *object.Commit, c *object.Commit, name string) (err error) {
+	parentContent, parentExists, err := commitFileContent(parent, name)
+	if err != nil {
+		return err
+	}
+	current, err := util.ReadFile(w.Filesystem, name)
+	currentExists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := c.File(name)
+	if errors.Is(err, object.ErrFileNotFound) { // c 删除了文件
+		if currentExists && (!parentExists || string(current) != parentContent) {
+			return &ConflictError{Files: []string{name}}
+		}
+		err = w.Filesystem.Remove(name)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return err
+	}
+	if currentExists != parentExists || string(current) != parentContent { // 远程也修改了该文件
+		if !currentExists {
+			return &ConflictError{Files: []string{name}}
+		}
+		var conflicts []ConflictHunk
+		content, conflicts = mergeText(parentContent, string(current), content)
+		if len(conflicts) > 0 {
+			return &ConflictError{Files: []string{name}}
+		}
+	}
+	return writeCommitFile(w, file, name, content)
+}
+
+func writeCommitFile(w *git.Worktree, file *object.File, name string, content string) (err error) {
+	mode, err := file.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	billyFile, err := w.Filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer billyFile.Close()
+	_, err = billyFile.Write([]byte(content))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// changedFiles from 到 to 之间变更的文件 => to 中的blob hash,删除的文件为 plumbing.ZeroHash
+func changedFiles(from *object.Commit, to *object.Commit) (files map[string]plumbing.Hash, err error) {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := fromTree.Diff(toTree)
+	if err != nil {
+		return nil, err
+	}
+	files = make(map[string]plumbing.Hash)
+	for _, change := range changes {
+		if change.From.Name != "" {
+			files[change.From.Name] = plumbing.ZeroHash
+		}
+		if change.To.Name != "" {
+			files[change.To.Name] = change.To.TreeEntry.Hash
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,164 @@
+package gitauto
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useRobotWorkDir 测试期间把 RobotWorkDir 设为临时目录,供包级函数(ReadFile、NewRepository 等)使用,结束后恢复
+func useRobotWorkDir(t *testing.T) (root string) {
+	old := RobotWorkDir
+	root = t.TempDir()
+	RobotWorkDir = root
+	t.Cleanup(func() {
+		RobotWorkDir = old
+	})
+	return root
+}
+
+// newBareRemote 创建本地裸仓库作为远程仓库,并提交files
+func newBareRemote(t *testing.T, files map[string]string) (remotePath string) {
+	remotePath = filepath.Join(t.TempDir(), "remote.git")
+	_, err := git.PlainInit(remotePath, true)
+	require.NoError(t, err)
+	seedDir := t.TempDir()
+	seed, err := git.PlainInit(seedDir, false)
+	require.NoError(t, err)
+	_, err = seed.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remotePath}})
+	require.NoError(t, err)
+	w, err := seed.Worktree()
+	require.NoError(t, err)
+	for name, content := range files {
+		writeWorktreeFile(t, w, name, content)
+	}
+	_, err = w.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	err = seed.Push(&git.PushOptions{})
+	require.NoError(t, err)
+	return remotePath
+}
+
+// cloneRemote 把远程仓库clone到临时目录并打开为 Repository
+func cloneRemote(t *testing.T, remotePath string) (rc *Repository) {
+	workDir := t.TempDir()
+	_, err := git.PlainClone(workDir, false, &git.CloneOptions{URL: remotePath})
+	require.NoError(t, err)
+	rc, err = NewClient().openRepository(context.Background(), remotePath, t.TempDir(), workDir, CloneOptions{})
+	require.NoError(t, err)
+	rc.PushRetry = PushRetry{}
+	return rc
+}
+
+func writeWorktreeFile(t *testing.T, w *git.Worktree, name string, content string) {
+	f, err := w.Filesystem.Create(name)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = w.Add(name)
+	require.NoError(t, err)
+}
+
+func TestCommitWithPushRebase(t *testing.T) {
+	remotePath := newBareRemote(t, map[string]string{"a.md": "a", "b.md": "b"})
+	user := User{Name: "robot", Email: "robot@example.com"}
+	rcA := cloneRemote(t, remotePath)
+	rcB := cloneRemote(t, remotePath)
+
+	err := rcA.AddReplaceFileToStage("a.md", []byte("a from A"))
+	require.NoError(t, err)
+	err = rcA.CommitWithPush("A", user)
+	require.NoError(t, err)
+
+	t.Run("rebase", func(t *testing.T) {
+		err := rcB.AddReplaceFileToStage("b.md", []byte("b from B"))
+		require.NoError(t, err)
+		err = rcB.CommitWithPush("B", user)
+		require.NoError(t, err)
+		b, err := rcB.ReadFile("a.md")
+		require.NoError(t, err)
+		require.Equal(t, "a from A", string(b))
+		head, err := rcB._r.Head()
+		require.NoError(t, err)
+		assert.Equal(t, head.Hash(), remoteBranchHash(t, remotePath, rcB.LocalBranch))
+	})
+
+	t.Run("merge same file", func(t *testing.T) {
+		rcC := cloneRemote(t, remotePath)
+		err := rcC.AddReplaceFileToStage("c.md", []byte("1\n2\n3\n4\n5\n"))
+		require.NoError(t, err)
+		err = rcC.CommitWithPush("C", user)
+		require.NoError(t, err)
+		rcD := cloneRemote(t, remotePath)
+		err = rcC.AddReplaceFileToStage("c.md", []byte("1 from C\n2\n3\n4\n5\n"))
+		require.NoError(t, err)
+		err = rcC.CommitWithPush("C2", user)
+		require.NoError(t, err)
+
+		err = rcD.AddReplaceFileToStage("c.md", []byte("1\n2\n3\n4\n5 from D\n"))
+		require.NoError(t, err)
+		err = rcD.CommitWithPush("D", user)
+		require.NoError(t, err)
+		b, err := rcD.ReadFile("c.md")
+		require.NoError(t, err)
+		require.Equal(t, "1 from C\n2\n3\n4\n5 from D\n", string(b))
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		err := rcA.AddReplaceFileToStage("b.md", []byte("b from A"))
+		require.NoError(t, err)
+		err = rcA.CommitWithPush("A2", user)
+		var conflictErr *ConflictError
+		require.ErrorAs(t, err, &conflictErr)
+		require.Equal(t, []string{"b.md"}, conflictErr.Files)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		rcE := cloneRemote(t, remotePath)
+		rcF := cloneRemote(t, remotePath)
+		err := rcF.AddReplaceFileToStage("f.md", []byte("f"))
+		require.NoError(t, err)
+		err = rcF.CommitWithPush("F", user)
+		require.NoError(t, err)
+
+		// 拉取不再更新远程跟踪分支,每次推送都会被拒绝
+		cfg, err := rcE._r.Config()
+		require.NoError(t, err)
+		cfg.Remotes[rcE.RemoteName].Fetch = []config.RefSpec{"+refs/heads/*:refs/remotes/stale/*"}
+		require.NoError(t, rcE._r.SetConfig(cfg))
+		logger := &bufferLogger{}
+		rcE._client = NewClient(WithLogger(logger))
+		rcE.PushRetry = PushRetry{MaxRetries: 2, Backoff: 10 * time.Millisecond}
+		err = rcE.AddReplaceFileToStage("e.md", []byte("e"))
+		require.NoError(t, err)
+		start := time.Now()
+		err = rcE.CommitWithPush("E", user)
+		require.ErrorIs(t, err, git.ErrNonFastForwardUpdate)
+		assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond, "backoff doubles: 10ms + 20ms")
+		assert.Equal(t, 2, strings.Count(logger.String(), "rejected, retry"))
+		fHead, err := rcF._r.Head()
+		require.NoError(t, err)
+		assert.Equal(t, fHead.Hash(), remoteBranchHash(t, remotePath, rcE.LocalBranch), "remote branch unchanged")
+	})
+}
+
+// remoteBranchHash 裸仓库中分支指向的提交
+func remoteBranchHash(t *testing.T, remotePath string, branch string) (hash plumbing.Hash) {
+	r, err := git.PlainOpen(remotePath)
+	require.NoError(t, err)
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(branch), true)
+	require.NoError(t, err)
+	return ref.Hash()
+}
@@ -0,0 +1,256 @@
+package gitauto
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/pkg/errors"
+)
+
+// QuarantineDirName 隔离目录(位于工作根目录下),损坏的工作目录移到这里保留现场
+const QuarantineDirName = ".quarantine"
+
+// maxRecoveryAttempts 一次打开仓库最多恢复的次数,避免修复后仍损坏时无限循环
+const maxRecoveryAttempts = 3
+
+// RecoveryPolicy 打开仓库时发现工作目录损坏的处理策略
+type RecoveryPolicy int
+
+const (
+	RecoveryRepair     RecoveryPolicy = iota // 原地修复(删除过期 index.lock、重新指向分支),无法修复时按 RecoveryQuarantine 处理(默认)
+	RecoveryReclone                          // 删除工作目录后重新clone
+	RecoveryQuarantine                       // 工作目录移到隔离目录后重新clone
+	RecoveryNone                             // 不处理,返回 *BrokenRepositoryError
+)
+
+func (p RecoveryPolicy) String() string {
+	switch p {
+	case RecoveryRepair:
+		return "repair"
+	case RecoveryReclone:
+		return "reclone"
+	case RecoveryQuarantine:
+		return "quarantine"
+	case RecoveryNone:
+		return "none"
+	}
+	return fmt.Sprintf("RecoveryPolicy(%d)", int(p))
+}
+
+// WithRecoveryPolicy 设置工作目录损坏时的处理策略
+func WithRecoveryPolicy(policy RecoveryPolicy) ClientOption {
+	return func(c *Client) {
+		c.recoveryPolicy = policy
+	}
+}
+
+// BrokenReason 工作目录损坏原因
+type BrokenReason string
+
+const (
+	BrokenOpen      BrokenReason = "open failed"
+	BrokenHead      BrokenReason = "missing HEAD"
+	BrokenObjects   BrokenReason = "missing objects"
+	BrokenIndexLock BrokenReason = "stale index lock"
+)
+
+// ErrBrokenRepository 工作目录损坏,可用 errors.Is 判断,详情见 *BrokenRepositoryError
+var ErrBrokenRepository = errors.New("broken repository")
+
+// BrokenRepositoryError 工作目录损坏(clone中断、对象丢失等)
+type BrokenRepositoryError struct {
+	WorkDir string
+	Reason  BrokenReason
+	Err     error
+}
+
+func (e *BrokenRepositoryError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("broken repository %s: %s", e.WorkDir, e.Reason)
+	}
+	return fmt.Sprintf("broken repository %s: %s: %v", e.WorkDir, e.Reason, e.Err)
+}
+
+func (e *BrokenRepositoryError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BrokenRepositoryError) Is(target error) bool {
+	return target == ErrBrokenRepository
+}
+
+// checkRepository 打开并检查工作目录,仓库不存在时 r、broken 均为空
+func checkRepository(workDir string) (r *git.Repository, broken *BrokenRepositoryError) {
+	gitDir := filepath.Join(workDir, git.GitDirName)
+	newBroken := func(reason BrokenReason, err error) *BrokenRepositoryError {
+		return &BrokenRepositoryError{WorkDir: workDir, Reason: reason, Err: err}
+	}
+	if s, err := os.Stat(filepath.Join(gitDir, "index.lock")); err == nil && time.Since(s.ModTime()) > StaleLockPeriod {
+		return nil, newBroken(BrokenIndexLock, nil)
+	}
+	r, err := git.PlainOpen(workDir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		if IsDir(gitDir) { // .git 存在但没有 HEAD,clone 中断
+			return nil, newBroken(BrokenHead, err)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newBroken(BrokenOpen, err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		return nil, newBroken(BrokenHead, err)
+	}
+	// HEAD 游离(如 clone 标签)时只要指向的提交完整即可使用
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, newBroken(BrokenObjects, err)
+	}
+	_, err = commit.Tree()
+	if err != nil {
+		return nil, newBroken(BrokenObjects, err)
+	}
+	return r, nil
+}
+
+// openOrClone 打开工作目录下的仓库,不存在时clone,损坏时按 RecoveryPolicy 恢复,调用方需持有文件锁
+func (c *Client) openOrClone(ctx context.Context, remoteUrl string, root string, workDir string, co CloneOptions) (r *git.Repository, err error) {
+	for attempt := 0; ; attempt++ {
+		r, broken := checkRepository(workDir)
+		if broken == nil {
+			if r != nil {
+				return r, nil
+			}
+			c.logf("gitauto: clone %s into %s", remoteUrl, workDir)
+			return c.cloneContext(ctx, remoteUrl, workDir, co)
+		}
+		if c.recoveryPolicy == RecoveryNone || attempt >= maxRecoveryAttempts {
+			return nil, broken
+		}
+		c.logf("gitauto: %v, recover with policy %s", broken, c.recoveryPolicy)
+		err = c.recoverWorkDir(root, broken)
+		if err != nil {
+			return nil, errors.WithMessage(err, broken.Error())
+		}
+	}
+}
+
+// recoverWorkDir 按策略处理损坏的工作目录,过期的 index.lock 只需删除
+func (c *Client) recoverWorkDir(root string, broken *BrokenRepositoryError) (err error) {
+	workDir := broken.WorkDir
+	if broken.Reason == BrokenIndexLock {
+		return os.Remove(filepath.Join(workDir, git.GitDirName, "index.lock"))
+	}
+	policy := c.recoveryPolicy
+	if policy == RecoveryRepair {
+		err = repairWorkDir(broken)
+		if err == nil {
+			return nil
+		}
+		c.logf("gitauto: repair %s: %v, quarantine", workDir, err)
+		policy = RecoveryQuarantine
+	}
+	switch policy {
+	case RecoveryReclone:
+		return os.RemoveAll(workDir)
+	case RecoveryQuarantine:
+		return c.quarantine(root, workDir)
+	}
+	return broken
+}
+
+// repairWorkDir 缺失 HEAD 时重新指向本地分支并重置工作区,对象丢失等无法原地修复时返回错误
+func repairWorkDir(broken *BrokenRepositoryError) (err error) {
+	if broken.Reason != BrokenHead {
+		return errors.Errorf("can not repair %s", broken.Reason)
+	}
+	gitDir := filepath.Join(broken.WorkDir, git.GitDirName)
+	storage := filesystem.NewStorage(osfs.New(gitDir), cache.NewObjectLRUDefault())
+	branch, err := defaultBranch(storage)
+	if err != nil {
+		return err
+	}
+	err = storage.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch))
+	if err != nil {
+		return err
+	}
+	r, err := git.PlainOpen(broken.WorkDir)
+	if err != nil {
+		return err
+	}
+	ref, err := r.Reference(branch, true)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	err = w.Reset(&git.ResetOptions{Commit: ref.Hash(), Mode: git.HardReset})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// defaultBranch 选择 HEAD 应指向的本地分支:优先配置中记录的跟踪分支,其次按名称排序的第一个本地分支
+func defaultBranch(storage *filesystem.Storage) (branch plumbing.ReferenceName, err error) {
+	cfg, err := storage.Config()
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(cfg.Branches))
+	for name := range cfg.Branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		branch = plumbing.NewBranchReferenceName(name)
+		if _, err := storage.Reference(branch); err == nil {
+			return branch, nil
+		}
+	}
+	refs, err := storage.IterReferences()
+	if err != nil {
+		return "", err
+	}
+	defer refs.Close()
+	branches := make([]string, 0)
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name().IsBranch() {
+			branches = append(branches, ref.Name().String())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(branches) == 0 {
+		return "", errors.New("no local branch")
+	}
+	sort.Strings(branches)
+	return plumbing.ReferenceName(branches[0]), nil
+}
+
+// quarantine 把损坏的工作目录移到隔离目录,如 RobotWorkDir/.quarantine/github.com_org_repo-20230301150405
+func (c *Client) quarantine(root string, workDir string) (err error) {
+	name := strings.ReplaceAll(strings.Trim(strings.TrimPrefix(workDir, root), "/"), "/", "_")
+	target := filepath.Join(root, QuarantineDirName, fmt.Sprintf("%s-%s", name, c.now().Format("20060102150405")))
+	err = os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	c.logf("gitauto: quarantine %s to %s", workDir, target)
+	return os.Rename(workDir, target)
+}
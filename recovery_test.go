@@ -0,0 +1,130 @@
+package gitauto
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecovery(t *testing.T) {
+	// setup clone 远程仓库后用 breakFn 破坏工作目录,返回新 Client 打开的结果
+	setup := func(t *testing.T, policy RecoveryPolicy, breakFn func(workDir string)) (rc *Repository, root string, err error) {
+		root = t.TempDir()
+		remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a"})
+		rc, err = NewClient(WithClientWorkDir(WorkDirConfig{Root: root})).NewRepository(remotePath)
+		require.NoError(t, err)
+		breakFn(rc._workDir)
+		c := NewClient(WithClientWorkDir(WorkDirConfig{Root: root}), WithRecoveryPolicy(policy))
+		rc, err = c.NewRepositoryContext(context.Background(), remotePath)
+		return rc, root, err
+	}
+	assertUsable := func(t *testing.T, rc *Repository) {
+		assert.Equal(t, "master", rc.LocalBranch)
+		b, err := rc.ReadFile("doc/a.md")
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b))
+	}
+	removeHead := func(workDir string) {
+		require.NoError(t, os.Remove(filepath.Join(workDir, ".git/HEAD")))
+	}
+	removeObjects := func(workDir string) {
+		packs, err := filepath.Glob(filepath.Join(workDir, ".git/objects/pack/*"))
+		require.NoError(t, err)
+		require.NotEmpty(t, packs)
+		for _, pack := range packs {
+			require.NoError(t, os.Remove(pack))
+		}
+	}
+
+	t.Run("missing HEAD repair", func(t *testing.T) {
+		rc, _, err := setup(t, RecoveryRepair, removeHead)
+		require.NoError(t, err)
+		assertUsable(t, rc)
+	})
+
+	t.Run("detached HEAD", func(t *testing.T) {
+		var hash plumbing.Hash
+		var remote plumbing.Hash
+		rc, root, err := setup(t, RecoveryQuarantine, func(workDir string) {
+			r, err := git.PlainOpen(workDir)
+			require.NoError(t, err)
+			head, err := r.Head()
+			require.NoError(t, err)
+			hash = head.Hash()
+			w, err := r.Worktree()
+			require.NoError(t, err)
+			require.NoError(t, w.Checkout(&git.CheckoutOptions{Hash: hash}))
+			ref, err := r.Reference(plumbing.NewRemoteReferenceName("origin", "master"), true)
+			require.NoError(t, err)
+			remote = ref.Hash()
+		})
+		require.NoError(t, err)
+		assert.NoDirExists(t, filepath.Join(root, QuarantineDirName), "complete detached clone is not broken")
+		err = rc.AddReplaceFileToStage("doc/a.md", []byte("a2"))
+		require.NoError(t, err)
+		err = rc.CommitWithPush("a2", User{Name: "robot", Email: "robot@example.com"})
+		require.ErrorIs(t, err, ErrDetachedHead, "commit on detached HEAD would never reach the remote")
+		head, err := rc._r.Head()
+		require.NoError(t, err)
+		assert.Equal(t, hash, head.Hash(), "nothing committed")
+		err = rc.push(context.Background())
+		require.ErrorIs(t, err, ErrDetachedHead)
+		require.NoError(t, rc.Fetch())
+		ref, err := rc._r.Reference(plumbing.NewRemoteReferenceName("origin", "master"), true)
+		require.NoError(t, err)
+		assert.Equal(t, remote, ref.Hash(), "remote unchanged")
+	})
+
+	t.Run("stale index lock", func(t *testing.T) {
+		var lock string
+		rc, _, err := setup(t, RecoveryNone, func(workDir string) {
+			lock = filepath.Join(workDir, ".git/index.lock")
+			require.NoError(t, os.WriteFile(lock, nil, 0644))
+			old := time.Now().Add(-2 * StaleLockPeriod)
+			require.NoError(t, os.Chtimes(lock, old, old))
+		})
+		require.ErrorIs(t, err, ErrBrokenRepository, "policy none keeps stale lock")
+		assert.Nil(t, rc)
+		require.FileExists(t, lock)
+
+		rc, _, err = setup(t, RecoveryRepair, func(workDir string) {
+			lock = filepath.Join(workDir, ".git/index.lock")
+			require.NoError(t, os.WriteFile(lock, nil, 0644))
+			old := time.Now().Add(-2 * StaleLockPeriod)
+			require.NoError(t, os.Chtimes(lock, old, old))
+		})
+		require.NoError(t, err)
+		assert.NoFileExists(t, lock)
+		assertUsable(t, rc)
+	})
+
+	t.Run("missing objects quarantine", func(t *testing.T) {
+		rc, root, err := setup(t, RecoveryRepair, removeObjects)
+		require.NoError(t, err)
+		assertUsable(t, rc)
+		entries, err := os.ReadDir(filepath.Join(root, QuarantineDirName))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("missing objects reclone", func(t *testing.T) {
+		rc, root, err := setup(t, RecoveryReclone, removeObjects)
+		require.NoError(t, err)
+		assertUsable(t, rc)
+		assert.NoDirExists(t, filepath.Join(root, QuarantineDirName))
+	})
+
+	t.Run("none", func(t *testing.T) {
+		_, _, err := setup(t, RecoveryNone, removeObjects)
+		var broken *BrokenRepositoryError
+		require.ErrorAs(t, err, &broken)
+		assert.Equal(t, BrokenObjects, broken.Reason)
+	})
+}
@@ -0,0 +1,272 @@
+package gitauto
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// ConflictHunk 人工修改与程序重新生成修改了同一区域,合并结果保留人工修改的内容
+type ConflictHunk struct {
+	BaseStart int      // 冲突区域在上次生成版本中的起始行号,从1开始
+	BaseEnd   int      // 冲突区域在上次生成版本中的结束行号(包含),区域为空(双方在同一位置插入)时为 BaseStart-1
+	Line      int      // 冲突区域在合并结果中的起始行号,从1开始
+	Base      []string // 上次生成版本的内容
+	Ours      []string // 当前文件(人工修改后)的内容,即合并结果中的内容
+	Theirs    []string // 新生成的内容
+}
+
+// RegenerateResult 重新生成的合并结果
+type RegenerateResult struct {
+	BaseCommit string // 上次生成版本所在提交,为空表示文件从未由程序提交,此时双方内容都视为新增
+	Content    []byte // 合并结果,已写入工作区
+	Conflicts  []ConflictHunk
+}
+
+// HasConflict 是否有冲突
+func (r RegenerateResult) HasConflict() bool {
+	return len(r.Conflicts) > 0
+}
+
+// Regenerate 以程序最近一次提交的版本为基础,三方合并当前文件(可能有人工修改)与新生成的内容并写入工作区:
+// 人工修改过的行保留,其它区域使用新生成的内容;双方修改同一区域时保留人工修改,并在 Conflicts 中报告。持有仓库锁执行
+func (rc *Repository) Regenerate(remoteOrLocalFilename string, newContent []byte, robot Author) (result *RegenerateResult, err error) {
+	err = rc.WithLock(context.Background(), func() (err error) {
+		result, err = rc.regenerate(remoteOrLocalFilename, newContent, robot)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (rc *Repository) regenerate(remoteOrLocalFilename string, newContent []byte, robot Author) (result *RegenerateResult, err error) {
+	filename := rc.repositoryFilename(remoteOrLocalFilename)
+	result = &RegenerateResult{}
+	current, err := rc.ReadFile(filename)
+	if os.IsNotExist(err) {
+		result.Content = newContent
+		return result, rc.addReplaceFileToStage(filename, newContent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	baseCommit, base, err := rc.lastRobotVersion(filename, robot)
+	if err != nil {
+		return nil, err
+	}
+	if baseCommit != nil {
+		result.BaseCommit = baseCommit.Hash.String()
+	}
+	merged, conflicts := merge3(splitLines(base), splitLines(string(current)), splitLines(string(newContent)))
+	content := strings.Join(merged, "\n")
+	if len(merged) > 0 && (strings.HasSuffix(string(newContent), "\n") || len(newContent) == 0 && strings.HasSuffix(string(current), "\n")) {
+		content += "\n"
+	}
+	result.Content = []byte(content)
+	result.Conflicts = conflicts
+	err = rc.addReplaceFileToStage(filename, result.Content)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// lastRobotVersion 从 HEAD 起修改过文件的提交中,作者为 robot(经 .mailmap 解析后比较)的最近一次提交及文件内容,没有时返回空
+func (rc *Repository) lastRobotVersion(filename string, robot Author) (commit *object.Commit, content string, err error) {
+	head, err := rc._r.Head()
+	if err != nil {
+		return nil, "", err
+	}
+	iter, err := rc._r.Log(&git.LogOptions{From: head.Hash(), FileName: &filename})
+	if err != nil {
+		return nil, "", err
+	}
+	defer iter.Close()
+	headCommit, err := rc._r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, "", err
+	}
+	ids, err := rc.identities(headCommit)
+	if err != nil {
+		return nil, "", err
+	}
+	robot = ids.resolve(robot.Name, robot.Email)
+	err = iter.ForEach(func(c *object.Commit) error {
+		if !ids.resolve(c.Author.Name, c.Author.Email).Equal(robot) {
+			return nil
+		}
+		commit = c
+		return storer.ErrStop
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if commit == nil {
+		return nil, "", nil
+	}
+	f, err := commit.File(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	content, err = f.Contents()
+	if err != nil {
+		return nil, "", err
+	}
+	return commit, content, nil
+}
+
+// mergeHunk 相对 base 的一处修改,把 base[start:end) 替换为 lines
+type mergeHunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+// overlap 两处修改是否涉及同一区域:范围相交、在同一位置开始,或一方在另一方范围内插入
+func (h mergeHunk) overlap(other mergeHunk) bool {
+	if h.start == other.start {
+		return true
+	}
+	if h.start < other.end && other.start < h.end {
+		return true
+	}
+	if h.start == h.end && other.start < h.start && h.start < other.end {
+		return true
+	}
+	return other.start == other.end && h.start < other.start && other.start < h.end
+}
+
+// diffHunks base 到 target 的行修改
+func diffHunks(base []string, target []string) (hunks []mergeHunk) {
+	pos := 0
+	var current *mergeHunk
+	flush := func() {
+		if current != nil {
+			hunks = append(hunks, *current)
+			current = nil
+		}
+	}
+	for _, d := range diff.Do(joinLines(base), joinLines(target)) {
+		lines := splitLines(d.Text)
+		if d.Type == diffmatchpatch.DiffEqual {
+			flush()
+			pos += len(lines)
+			continue
+		}
+		if current == nil {
+			current = &mergeHunk{start: pos, end: pos}
+		}
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			pos += len(lines)
+			current.end = pos
+		case diffmatchpatch.DiffInsert:
+			current.lines = append(current.lines, lines...)
+		}
+	}
+	flush()
+	return hunks
+}
+
+// joinLines 每行都以换行结尾,避免最后一行有无换行影响比较
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// applyHunks 把一方在 base[start:end) 内的修改应用到该区域
+func applyHunks(base []string, start int, end int, hunks []mergeHunk) (lines []string) {
+	lines = make([]string, 0, end-start)
+	pos := start
+	for _, h := range hunks {
+		lines = append(lines, base[pos:h.start]...)
+		lines = append(lines, h.lines...)
+		pos = h.end
+	}
+	lines = append(lines, base[pos:end]...)
+	return lines
+}
+
+// merge3 三方合并,只有一方修改的区域使用该方内容,双方修改同一区域且结果不同时使用 ours 并记录冲突
+func merge3(base []string, ours []string, theirs []string) (merged []string, conflicts []ConflictHunk) {
+	ourHunks, theirHunks := diffHunks(base, ours), diffHunks(base, theirs)
+	merged = make([]string, 0, len(ours))
+	pos, i, j := 0, 0, 0
+	for i < len(ourHunks) || j < len(theirHunks) {
+		// 取起始位置最小的修改,合并与其相关的所有修改为一个区域
+		var region mergeHunk
+		if j >= len(theirHunks) || i < len(ourHunks) && ourHunks[i].start <= theirHunks[j].start {
+			region = mergeHunk{start: ourHunks[i].start, end: ourHunks[i].end}
+		} else {
+			region = mergeHunk{start: theirHunks[j].start, end: theirHunks[j].end}
+		}
+		ourRegion, theirRegion := make([]mergeHunk, 0), make([]mergeHunk, 0)
+		for grown := true; grown; {
+			grown = false
+			if i < len(ourHunks) && ourHunks[i].overlap(region) {
+				region = extend(region, ourHunks[i])
+				ourRegion = append(ourRegion, ourHunks[i])
+				i, grown = i+1, true
+			}
+			if j < len(theirHunks) && theirHunks[j].overlap(region) {
+				region = extend(region, theirHunks[j])
+				theirRegion = append(theirRegion, theirHunks[j])
+				j, grown = j+1, true
+			}
+		}
+		merged = append(merged, base[pos:region.start]...)
+		pos = region.end
+		ourLines := applyHunks(base, region.start, region.end, ourRegion)
+		theirLines := applyHunks(base, region.start, region.end, theirRegion)
+		switch {
+		case len(theirRegion) == 0:
+			merged = append(merged, ourLines...)
+		case len(ourRegion) == 0, equalLines(ourLines, theirLines):
+			merged = append(merged, theirLines...)
+		default:
+			conflicts = append(conflicts, ConflictHunk{
+				BaseStart: region.start + 1,
+				BaseEnd:   region.end,
+				Line:      len(merged) + 1,
+				Base:      append([]string{}, base[region.start:region.end]...),
+				Ours:      ourLines,
+				Theirs:    theirLines,
+			})
+			merged = append(merged, ourLines...)
+		}
+	}
+	merged = append(merged, base[pos:]...)
+	return merged, conflicts
+}
+
+func extend(region mergeHunk, h mergeHunk) mergeHunk {
+	if h.start < region.start {
+		region.start = h.start
+	}
+	if h.end > region.end {
+		region.end = h.end
+	}
+	return region
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package gitauto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge3(t *testing.T) {
+	lines := func(s string) []string {
+		return splitLines(s)
+	}
+	cases := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		merged    string
+		conflicts int
+	}{
+		{"only robot changed", "a\nb\nc\n", "a\nb\nc\n", "a\nB\nc\nd\n", "a\nB\nc\nd\n", 0},
+		{"only human changed", "a\nb\nc\n", "a\nhuman\nc\n", "a\nb\nc\n", "a\nhuman\nc\n", 0},
+		{"different regions", "a\nb\nc\nd\ne\n", "a\nhuman\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "a\nhuman\nc\nd\nE\n", 0},
+		{"same change", "a\nb\n", "a\nB\n", "a\nB\n", "a\nB\n", 0},
+		{"human deleted line", "a\nb\nc\n", "a\nc\n", "a\nb\nC\n", "a\nC\n", 0},
+		{"conflict", "a\nb\nc\n", "a\nhuman\nc\n", "a\nrobot\nc\n", "a\nhuman\nc\n", 1},
+		{"insert at same place", "a\n", "a\nhuman\n", "a\nrobot\n", "a\nhuman\n", 1},
+		{"no base", "", "human\n", "robot\n", "human\n", 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			merged, conflicts := merge3(lines(c.base), lines(c.ours), lines(c.theirs))
+			assert.Equal(t, c.merged, joinLines(merged))
+			assert.Len(t, conflicts, c.conflicts)
+		})
+	}
+
+	_, conflicts := merge3(lines("a\nb\nc\n"), lines("a\nhuman\nc\n"), lines("a\nrobot\nc\n"))
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, ConflictHunk{BaseStart: 2, BaseEnd: 2, Line: 2, Base: []string{"b"}, Ours: []string{"human"}, Theirs: []string{"robot"}}, conflicts[0])
+}
+
+func TestRegenerate(t *testing.T) {
+	rc := newLocalRepository(t, map[string]string{"doc/a.md": "title\n"})
+	robot := NewAuthor("robot", "robot@example.com")
+	commitAs(t, rc, robot.Email, map[string]string{"doc/a.md": "title\nfield1\nfield2\nfield3\n"})
+	commitAs(t, rc, "alice@example.com", map[string]string{"doc/a.md": "title\nfield1 // checked by alice\nfield2\nfield3\n"})
+
+	result, err := rc.Regenerate("doc/a.md", []byte("title\nfield1\nfield2\nfield3\nfield4\n"), robot)
+	require.NoError(t, err)
+	assert.False(t, result.HasConflict())
+	assert.NotEmpty(t, result.BaseCommit)
+	b, err := rc.ReadFile("doc/a.md")
+	require.NoError(t, err)
+	assert.Equal(t, "title\nfield1 // checked by alice\nfield2\nfield3\nfield4\n", string(b))
+
+	result, err = rc.Regenerate("doc/a.md", []byte("title\nfield1 int\nfield2\nfield3\nfield4\n"), robot)
+	require.NoError(t, err)
+	require.True(t, result.HasConflict())
+	assert.Equal(t, []string{"field1 // checked by alice"}, result.Conflicts[0].Ours)
+	assert.Equal(t, []string{"field1 int"}, result.Conflicts[0].Theirs)
+	assert.True(t, strings.HasPrefix(string(result.Content), "title\nfield1 // checked by alice\n"))
+
+	result, err = rc.Regenerate("doc/new.md", []byte("new\n"), robot)
+	require.NoError(t, err)
+	assert.Equal(t, "new\n", string(result.Content))
+}
@@ -0,0 +1,211 @@
+package gitauto
+
+import (
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/pkg/errors"
+)
+
+// ErrNoRepository 地址中没有以 .git 结尾的仓库路径
+var ErrNoRepository = errors.New("address has no .git repository path")
+
+// RemoteFile 远程文件地址,支持以下形式(均可带版本,格式见 splitRemoteAddress):
+//
+//	https://github.com/org/repo.git/doc/a.md
+//	ssh://git@gitea.programmerfamily.com:2221/go/coupon.git/doc/a.md
+//	git@github.com:org/repo.git/doc/a.md
+//	file:///srv/repos/repo.git/doc/a.md
+//	/srv/repos/repo.git/doc/a.md、D:\repos\repo.git\doc\a.md
+type RemoteFile struct {
+	Scheme   string // https、http、ssh、file 等,scp 形式为 ssh,本地路径形式为 file
+	SCPLike  bool   // git@host:org/repo.git 形式
+	Local    bool   // 本地路径形式,如 /srv/repos/repo.git
+	User     string
+	Password string
+	Host     string // 不含端口
+	Port     string
+	RepoPath string // 仓库路径,不以"/"开头,如 org/repo.git
+	FilePath string // 仓库内文件名,不以"/"开头
+	Ref      string // 分支、标签或提交hash,为空表示当前分支
+}
+
+// refFragmentPattern 地址末尾的版本片段 "#ref=<版本>",其它位置的"#"属于文件名
+var refFragmentPattern = regexp.MustCompile(`#ref=([^#]*)$`)
+
+// scpPattern git@host:path 形式,host 不能包含"/"
+var scpPattern = regexp.MustCompile(`^(?:([^@/]+)@)?([^:/]+):(.*)$`)
+
+// ParseRemoteFile 解析远程文件地址,仓库路径为第一个以 .git 结尾的路径段(可跟 "@版本"),
+// 地址中没有这样的路径段时返回 ErrNoRepository
+func ParseRemoteFile(address string) (rf *RemoteFile, err error) {
+	return parseAddress(address, false)
+}
+
+// parseRepositoryURL 解析仓库地址,没有以 .git 结尾的路径段时整个路径当作仓库路径(如 https://github.com/org/repo)
+func parseRepositoryURL(remoteUrl string) (rf *RemoteFile, err error) {
+	return parseAddress(remoteUrl, true)
+}
+
+func parseAddress(address string, wholePathIsRepo bool) (rf *RemoteFile, err error) {
+	rf = &RemoteFile{}
+	s := address
+	if matched := refFragmentPattern.FindStringSubmatchIndex(s); matched != nil {
+		rf.Ref, err = url.QueryUnescape(s[matched[2]:matched[3]])
+		if err != nil {
+			return nil, errors.WithMessagef(err, "parse fragment of %s", address)
+		}
+		s = s[:matched[0]]
+	}
+	var path string
+	if isLocalPath(s) {
+		rf.Scheme = "file"
+		rf.Local = true
+		path = strings.ReplaceAll(s, `\`, "/")
+		if !filepath.IsAbs(s) && !windowsPathPattern.MatchString(s) { // 相对路径
+			path, err = filepath.Abs(s)
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.ToSlash(path)
+		}
+	} else if strings.Contains(s, "://") {
+		u, err := url.Parse(strings.ReplaceAll(s, "#", "%23")) // 剩余的"#"属于文件名
+		if err != nil {
+			return nil, err
+		}
+		rf.Scheme = strings.ToLower(u.Scheme)
+		if u.User != nil {
+			rf.User = u.User.Username()
+			rf.Password, _ = u.User.Password()
+		}
+		rf.Host = u.Hostname()
+		rf.Port = u.Port()
+		path = u.Path
+	} else if matched := scpPattern.FindStringSubmatch(s); matched != nil {
+		rf.Scheme = "ssh"
+		rf.SCPLike = true
+		rf.User = matched[1]
+		rf.Host = matched[2]
+		path = matched[3]
+	} else {
+		err = errors.Errorf("unsupported remote address %s", address)
+		return nil, err
+	}
+	err = rf.splitPath(path, wholePathIsRepo)
+	if err != nil {
+		return nil, errors.WithMessage(err, address)
+	}
+	return rf, nil
+}
+
+// splitPath 把路径拆分为仓库路径和仓库内文件名,路径段形如 repo.git@v1.2.0 时同时得到版本
+func (rf *RemoteFile) splitPath(path string, wholePathIsRepo bool) (err error) {
+	path = strings.Trim(path, "/")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		repoSegment, atRef := segment, ""
+		if atIndex := strings.Index(segment, git.GitDirName+"@"); atIndex > -1 {
+			repoSegment, atRef = segment[:atIndex+len(git.GitDirName)], segment[atIndex+len(git.GitDirName)+1:]
+		}
+		if !strings.HasSuffix(repoSegment, git.GitDirName) {
+			continue
+		}
+		rf.RepoPath = strings.Join(append(segments[:i:i], repoSegment), "/")
+		rf.FilePath = strings.Join(segments[i+1:], "/")
+		if rf.Ref == "" {
+			rf.Ref = atRef
+		}
+		return nil
+	}
+	if wholePathIsRepo {
+		rf.RepoPath = path
+		return nil
+	}
+	return ErrNoRepository
+}
+
+// windowsPathPattern Windows 盘符开头的路径,如 D:\repos、D:/repos
+var windowsPathPattern = regexp.MustCompile(`^[a-zA-Z]:[\\/]`)
+
+// isLocalPath 是否为本地文件系统路径(绝对路径、./ 或 ../ 开头的相对路径、Windows 盘符路径)
+func isLocalPath(address string) bool {
+	if strings.HasPrefix(address, "/") || strings.HasPrefix(address, "./") || strings.HasPrefix(address, "../") {
+		return true
+	}
+	return windowsPathPattern.MatchString(address)
+}
+
+// String 还原为地址,版本包含"/"时使用 "#ref=" 形式,否则使用 "@" 形式
+func (rf RemoteFile) String() (address string) {
+	address = rf.RepositoryURL()
+	refInFragment := strings.Contains(rf.Ref, "/")
+	if rf.Ref != "" && !refInFragment {
+		address = address + "@" + rf.Ref
+	}
+	if rf.FilePath != "" {
+		address = address + "/" + rf.FilePath
+	}
+	if refInFragment {
+		address = address + "#ref=" + rf.Ref
+	}
+	return address
+}
+
+// RepositoryURL 仓库地址,不含版本和仓库内文件名,可直接用于clone
+func (rf RemoteFile) RepositoryURL() (remoteUrl string) {
+	if rf.Local {
+		remoteUrl = rf.RepoPath
+		if !windowsPathPattern.MatchString(remoteUrl) {
+			remoteUrl = "/" + remoteUrl
+		}
+		return remoteUrl
+	}
+	if rf.SCPLike {
+		remoteUrl = rf.Host + ":" + rf.RepoPath
+		if rf.User != "" {
+			remoteUrl = rf.User + "@" + remoteUrl
+		}
+		return remoteUrl
+	}
+	return rf.URL().String()
+}
+
+// URL 仓库地址对应的 *url.URL,scp 形式转换为 ssh://
+func (rf RemoteFile) URL() (u *url.URL) {
+	u = &url.URL{
+		Scheme: rf.Scheme,
+		Host:   rf.Host,
+		Path:   "/" + rf.RepoPath,
+	}
+	if rf.Port != "" {
+		u.Host = net.JoinHostPort(rf.Host, rf.Port)
+	} else if strings.Contains(rf.Host, ":") { // IPv6
+		u.Host = "[" + rf.Host + "]"
+	}
+	if rf.Password != "" {
+		u.User = url.UserPassword(rf.User, rf.Password)
+	} else if rf.User != "" {
+		u.User = url.User(rf.User)
+	}
+	return u
+}
+
+// LocalHost 本地仓库(file:// 或本地路径)工作目录使用的主机名
+const LocalHost = "local"
+
+// relativeWorkDir 相对 RobotWorkDir 的工作目录,如 github.com/org/repo,本地仓库为 local/srv/repos/repo
+func (rf RemoteFile) relativeWorkDir() (repositoryPath string) {
+	host := rf.Host
+	repositoryPath = strings.TrimSuffix(rf.RepoPath, git.GitDirName)
+	if rf.Scheme == "file" {
+		host = LocalHost
+		repositoryPath = strings.Replace(repositoryPath, ":", "", 1) // Windows 盘符
+	}
+	repositoryPath = strings.Trim(host+"/"+strings.Trim(repositoryPath, "/"), "/")
+	return repositoryPath
+}
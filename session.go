@@ -0,0 +1,49 @@
+package gitauto
+
+import (
+	"context"
+)
+
+// Session 持有仓库锁期间操作工作区,写入与提交在同一个临界区内完成,其它调用方不会在两者之间提交或覆盖写入的文件;
+// 方法假定锁已持有,只能在 WithSession 的回调内使用
+type Session struct {
+	rc *Repository
+}
+
+// WithSession 获取仓库锁后执行fn,fn 返回后释放。Lock 不可重入,回调内应调用 Session 的方法,
+// 而不是 Repository 上自行加锁的方法(AddReplaceFileToStage、CommitWithPush 等),否则会一直等待
+func (rc *Repository) WithSession(ctx context.Context, fn func(s *Session) error) (err error) {
+	return rc.WithLock(ctx, func() error {
+		return fn(&Session{rc: rc})
+	})
+}
+
+// AddReplaceFileToStage 同 Repository.AddReplaceFileToStage,不再加锁
+func (s *Session) AddReplaceFileToStage(remoteFilename string, content []byte) (err error) {
+	return s.rc.addReplaceFileToStage(remoteFilename, content)
+}
+
+// DeleteFile 同 Repository.DeleteFile,不再加锁
+func (s *Session) DeleteFile(remoteFilenames ...string) (err error) {
+	return s.rc.deleteFile(remoteFilenames...)
+}
+
+// Regenerate 同 Repository.Regenerate,不再加锁
+func (s *Session) Regenerate(remoteOrLocalFilename string, newContent []byte, robot Author) (result *RegenerateResult, err error) {
+	return s.rc.regenerate(remoteOrLocalFilename, newContent, robot)
+}
+
+// CommitWithPush 同 Repository.CommitWithPushContext,不再加锁
+func (s *Session) CommitWithPush(ctx context.Context, commitMsg string, user User) (err error) {
+	return s.rc.commitWithPush(ctx, commitMsg, user)
+}
+
+// CommitTouchedWithPush 同 Repository.CommitTouchedWithPushContext,不再加锁
+func (s *Session) CommitTouchedWithPush(ctx context.Context, commitMsg string, user User) (err error) {
+	return s.rc.commitTouchedWithPush(ctx, commitMsg, user)
+}
+
+// Propose 同 Repository.Propose,不再加锁
+func (s *Session) Propose(ctx context.Context, opts ProposeOptions) (result *PullRequestResult, err error) {
+	return s.rc.propose(ctx, opts)
+}
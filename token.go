@@ -0,0 +1,173 @@
+package gitauto
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/pkg/errors"
+)
+
+// https token 对应的用户名,服务端只校验密码位置的 token
+const (
+	PersonalAccessTokenUser = "oauth2"          // 个人访问令牌,Gitea、GitHub、GitLab 均接受
+	GitHubAppTokenUser      = "x-access-token"  // GitHub App installation token
+	GitLabJobTokenUser      = "gitlab-ci-token" // GitLab CI 的 CI_JOB_TOKEN
+)
+
+// DefaultTokenRefreshBefore token 过期前多久重新生成,避免网络操作途中过期
+const DefaultTokenRefreshBefore = time.Minute
+
+// PersonalAccessToken 个人访问令牌(Gitea、GitHub、GitLab)的验证配置
+func PersonalAccessToken(token string) *http.BasicAuth {
+	return &http.BasicAuth{Username: PersonalAccessTokenUser, Password: token}
+}
+
+// GitHubAppToken GitHub App installation token 的验证配置,token 一小时后过期,需定期更新的见 NewRefreshingToken
+func GitHubAppToken(token string) *http.BasicAuth {
+	return &http.BasicAuth{Username: GitHubAppTokenUser, Password: token}
+}
+
+// GitLabJobToken GitLab CI 任务 token(CI_JOB_TOKEN)的验证配置
+func GitLabJobToken(token string) *http.BasicAuth {
+	return &http.BasicAuth{Username: GitLabJobTokenUser, Password: token}
+}
+
+// BearerToken 以 Authorization: Bearer 方式发送 token 的验证配置
+func BearerToken(token string) *http.TokenAuth {
+	return &http.TokenAuth{Token: token}
+}
+
+// TokenSource 生成短期 token,expiry 为零值表示不过期
+type TokenSource func(ctx context.Context) (token string, expiry time.Time, err error)
+
+// Refresher 需要在网络操作前更新的验证配置,查询到验证配置后调用 Refresh
+type Refresher interface {
+	Refresh(ctx context.Context) (err error)
+}
+
+// RefreshingToken 短期 token 验证配置,首次使用及即将过期时调用 Source 重新生成,
+// 实现 http.AuthMethod,每次网络操作(clone、pull、push)前由 Client 调用 Refresh
+type RefreshingToken struct {
+	Username      string
+	Source        TokenSource
+	RefreshBefore time.Duration // 过期前多久重新生成,为0时使用 DefaultTokenRefreshBefore
+	Clock         Clock         // 判断是否过期的时钟,为 nil 时使用系统时间;Client 注册的使用 Client 的时钟
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewRefreshingToken 新建短期 token 验证配置,username 如 GitHubAppTokenUser
+func NewRefreshingToken(username string, source TokenSource) *RefreshingToken {
+	return &RefreshingToken{Username: username, Source: source}
+}
+
+func (a *RefreshingToken) Name() string {
+	return "http-refreshing-token"
+}
+
+func (a *RefreshingToken) String() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	masked := "*******"
+	if a.token == "" {
+		masked = "<empty>"
+	}
+	return fmt.Sprintf("%s - %s:%s (expiry %s)", a.Name(), a.Username, masked, a.expiry.Format(time.RFC3339))
+}
+
+func (a *RefreshingToken) SetAuth(r *nethttp.Request) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r.SetBasicAuth(a.Username, a.token)
+}
+
+// expired 没有 token 或即将过期
+func (a *RefreshingToken) expired(now time.Time) bool {
+	if a.token == "" {
+		return true
+	}
+	if a.expiry.IsZero() {
+		return false
+	}
+	refreshBefore := a.RefreshBefore
+	if refreshBefore == 0 {
+		refreshBefore = DefaultTokenRefreshBefore
+	}
+	return !now.Add(refreshBefore).Before(a.expiry)
+}
+
+// Refresh 没有 token 或即将过期时重新生成
+func (a *RefreshingToken) Refresh(ctx context.Context) (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if a.Clock != nil {
+		now = a.Clock.Now()
+	}
+	if !a.expired(now) {
+		return nil
+	}
+	token, expiry, err := a.Source(ctx)
+	if err != nil {
+		return errors.WithMessagef(err, "refresh token for %s", a.Username)
+	}
+	if token == "" {
+		return errors.Errorf("refresh token for %s: empty token", a.Username)
+	}
+	a.token, a.expiry = token, expiry
+	return nil
+}
+
+var _ http.AuthMethod = (*RefreshingToken)(nil)
+
+// refreshAuth 验证配置需要更新时更新
+func refreshAuth(ctx context.Context, auth transport.AuthMethod) (err error) {
+	refresher, ok := auth.(Refresher)
+	if !ok {
+		return nil
+	}
+	return refresher.Refresh(ctx)
+}
+
+// RegisterPersonalAccessToken 为匹配规则(见 RegisterAuthPattern)注册个人访问令牌,使用 DefaultClient
+func RegisterPersonalAccessToken(pattern string, token string) (err error) {
+	return DefaultClient.RegisterPersonalAccessToken(pattern, token)
+}
+
+// RegisterGitHubAppToken 为匹配规则注册 GitHub App installation token,使用 DefaultClient
+func RegisterGitHubAppToken(pattern string, source TokenSource) (err error) {
+	return DefaultClient.RegisterGitHubAppToken(pattern, source)
+}
+
+// RegisterGitLabJobToken 为匹配规则注册 GitLab CI 任务 token,使用 DefaultClient
+func RegisterGitLabJobToken(pattern string, token string) (err error) {
+	return DefaultClient.RegisterGitLabJobToken(pattern, token)
+}
+
+// RegisterPersonalAccessToken 为匹配规则注册个人访问令牌,如 c.RegisterPersonalAccessToken("gitea.example.com", os.Getenv("GITEA_TOKEN"))
+func (c *Client) RegisterPersonalAccessToken(pattern string, token string) (err error) {
+	return c.RegisterAuthPattern(pattern, PersonalAccessToken(token))
+}
+
+// RegisterGitHubAppToken 为匹配规则注册 GitHub App installation token,source 生成新 token(如用 App 私钥调用
+// POST /app/installations/{id}/access_tokens),过期前在 Pull、CommitWithPush 等网络操作前自动重新生成
+func (c *Client) RegisterGitHubAppToken(pattern string, source TokenSource) (err error) {
+	auth := NewRefreshingToken(GitHubAppTokenUser, source)
+	auth.Clock = c.clock
+	return c.RegisterAuthPattern(pattern, auth)
+}
+
+// RegisterGitLabJobToken 为匹配规则注册 GitLab CI 任务 token,如 c.RegisterGitLabJobToken(os.Getenv("CI_SERVER_HOST"), os.Getenv("CI_JOB_TOKEN"))
+func (c *Client) RegisterGitLabJobToken(pattern string, token string) (err error) {
+	return c.RegisterAuthPattern(pattern, GitLabJobToken(token))
+}
@@ -0,0 +1,101 @@
+package gitauto
+
+import (
+	"context"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenAuth(t *testing.T) {
+	assert.Equal(t, "oauth2", PersonalAccessToken("pat").Username)
+	assert.Equal(t, "x-access-token", GitHubAppToken("ghs").Username)
+	assert.Equal(t, "gitlab-ci-token", GitLabJobToken("job").Username)
+	assert.Equal(t, "job", GitLabJobToken("job").Password)
+
+	var mu sync.Mutex
+	passwords := make([]string, 0)
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		username, password, _ := r.BasicAuth()
+		mu.Lock()
+		passwords = append(passwords, username+":"+password)
+		mu.Unlock()
+		w.WriteHeader(nethttp.StatusUnauthorized)
+	}))
+	defer server.Close()
+	seen := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), passwords...)
+	}
+
+	remotePath := newBareRemote(t, map[string]string{"doc/a.md": "a"})
+	root := t.TempDir()
+	c := NewClient(WithClientWorkDir(WorkDirConfig{Root: root}), WithCredentialProviders(EnvCredentials{Prefix: "NOT_SET"}))
+	rc, err := c.NewRepository(remotePath)
+	require.NoError(t, err)
+	cfg, err := rc._r.Config()
+	require.NoError(t, err)
+	cfg.Remotes["origin"].URLs = []string{server.URL + "/org/repo.git"}
+	require.NoError(t, rc._r.SetConfig(cfg))
+
+	t.Run("refresh when expired", func(t *testing.T) {
+		minted := 0
+		err := c.RegisterGitHubAppToken("127.0.0.1", func(ctx context.Context) (string, time.Time, error) {
+			minted++
+			return "ghs_" + string(rune('0'+minted)), time.Now().Add(30 * time.Second), nil
+		})
+		require.NoError(t, err)
+		mu.Lock()
+		passwords = passwords[:0]
+		mu.Unlock()
+		_ = rc.Fetch()
+		_ = rc.Fetch()
+		assert.Equal(t, 2, minted, "token expiring within DefaultTokenRefreshBefore is re-minted")
+		assert.Contains(t, seen(), "x-access-token:ghs_1")
+		assert.Contains(t, seen(), "x-access-token:ghs_2")
+	})
+
+	t.Run("reuse until expiry", func(t *testing.T) {
+		minted := 0
+		err := c.RegisterGitHubAppToken("127.0.0.1", func(ctx context.Context) (string, time.Time, error) {
+			minted++
+			return "ghs_long", time.Now().Add(time.Hour), nil
+		})
+		require.NoError(t, err)
+		_ = rc.Fetch()
+		_ = rc.Fetch()
+		assert.Equal(t, 1, minted)
+	})
+
+	t.Run("refresh failure", func(t *testing.T) {
+		err := c.RegisterGitHubAppToken("127.0.0.1", func(ctx context.Context) (string, time.Time, error) {
+			return "", time.Time{}, nil
+		})
+		require.NoError(t, err)
+		err = rc.Fetch()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "empty token")
+	})
+
+	t.Run("client clock", func(t *testing.T) {
+		when := time.Date(2023, 3, 1, 15, 4, 5, 0, time.UTC)
+		c := NewClient(WithClock(fixedClock(when)))
+		minted := 0
+		err := c.RegisterGitHubAppToken("127.0.0.1", func(ctx context.Context) (string, time.Time, error) {
+			minted++
+			return "ghs_clock", when.Add(time.Hour), nil // 按系统时间已过期
+		})
+		require.NoError(t, err)
+		auth, ok := c.GetAuth("", "127.0.0.1")
+		require.True(t, ok)
+		require.NoError(t, refreshAuth(context.Background(), auth))
+		require.NoError(t, refreshAuth(context.Background(), auth))
+		assert.Equal(t, 1, minted)
+	})
+}
@@ -0,0 +1,100 @@
+package gitauto
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// WorkDirResolver 工作目录布局,根据仓库地址计算相对工作根目录的工作目录
+type WorkDirResolver interface {
+	RelativeWorkDir(rf RemoteFile) (relativePath string)
+}
+
+// HostPathLayout host/path 布局,如 github.com/org/repo,本地仓库为 local/srv/repos/repo(默认布局)
+type HostPathLayout struct{}
+
+func (HostPathLayout) RelativeWorkDir(rf RemoteFile) (relativePath string) {
+	return rf.relativeWorkDir()
+}
+
+// AnonymousUser HostUserPathLayout 中地址不带用户名时使用的用户目录
+const AnonymousUser = "anonymous"
+
+// HostUserPathLayout host/user/path 布局,如 github.com/git/org/repo,同一仓库不同用户(凭证)的clone互不干扰
+type HostUserPathLayout struct{}
+
+func (HostUserPathLayout) RelativeWorkDir(rf RemoteFile) (relativePath string) {
+	user := rf.User
+	if user == "" {
+		user = AnonymousUser
+	}
+	host, repositoryPath, _ := strings.Cut(rf.relativeWorkDir(), "/")
+	relativePath = strings.TrimRight(fmt.Sprintf("%s/%s/%s", host, user, repositoryPath), "/")
+	return relativePath
+}
+
+// HashedLayout 哈希布局,如 3f/3f2a...,目录名为仓库地址(含用户名,不含密码)的sha1,路径长度固定,
+// 适合仓库路径很深或包含特殊字符的场景
+type HashedLayout struct{}
+
+func (HashedLayout) RelativeWorkDir(rf RemoteFile) (relativePath string) {
+	rf.Password, rf.Ref, rf.FilePath = "", "", ""
+	sum := fmt.Sprintf("%x", sha1.Sum([]byte(rf.RepositoryURL())))
+	relativePath = fmt.Sprintf("%s/%s", sum[:2], sum)
+	return relativePath
+}
+
+// WorkDirConfig 工作目录配置,同一进程内不同服务可使用不同的根目录和布局
+type WorkDirConfig struct {
+	Root     string          // 工作根目录,为空时使用 RobotWorkDir
+	Resolver WorkDirResolver // 目录布局,为空时使用 HostPathLayout
+}
+
+// WithWorkDirConfig 设置工作目录配置
+func WithWorkDirConfig(c WorkDirConfig) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.workDir = c
+	}
+}
+
+// RootDir 工作根目录
+func (c WorkDirConfig) RootDir() (root string) {
+	root = c.Root
+	if root == "" {
+		root = RobotWorkDir
+	}
+	return strings.TrimRight(filepath.ToSlash(root), "/")
+}
+
+func (c WorkDirConfig) resolver() (resolver WorkDirResolver) {
+	if c.Resolver == nil {
+		return HostPathLayout{}
+	}
+	return c.Resolver
+}
+
+// WorkDir 仓库地址(可带版本和仓库内文件名)对应的工作目录
+func (c WorkDirConfig) WorkDir(remoteFilename string) (path string) {
+	repositoryPath := remoteFilename
+	rf, err := parseRepositoryURL(remoteFilename)
+	if err == nil {
+		repositoryPath = c.resolver().RelativeWorkDir(*rf)
+	}
+	repositoryPath = strings.TrimSuffix(repositoryPath, git.GitDirName)
+	path = fmt.Sprintf("%s/%s", c.RootDir(), repositoryPath)
+	path = strings.TrimRight(path, "/")
+	return path
+}
+
+// repositoryFilename 同 RepositoryFilename,本地文件名位于仓库工作目录(可能不在 RobotWorkDir 下)时直接截取
+func (rc *Repository) repositoryFilename(remoteOrLocalFilename string) (repositoryFilename string) {
+	prefix := rc._workDir + "/"
+	if strings.HasPrefix(filepath.ToSlash(remoteOrLocalFilename), prefix) {
+		return strings.TrimPrefix(filepath.ToSlash(remoteOrLocalFilename), prefix)
+	}
+	return RepositoryFilename(remoteOrLocalFilename)
+}